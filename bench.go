@@ -0,0 +1,194 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// runBench 打包性能对比：同一批交易分别放入堆实现的 TxPool 和原先每次全量排序的 sortTxPool，
+// 每轮补满交易池、随机改变 Tax/Subsidy 后各打包一个区块，比较耗时、内存分配和打包结果
+func runBench(args []string) {
+	fs := flag.NewFlagSet("taxsim bench", flag.ExitOnError)
+	poolSize := fs.Int("pool", 50000, "每轮打包前交易池中的交易数")
+	blockSize := fs.Int("block-size", 2000, "每个区块最多打包的交易数")
+	rounds := fs.Int("rounds", 30, "打包轮数")
+	ctxRatio := fs.Float64("ctx-ratio", 0.75, "跨分片交易占比")
+	seed := fs.Int64("seed", 1, "随机数种子")
+	fs.Parse(args)
+	if *poolSize <= 0 || *blockSize <= 0 || *rounds <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	rng := rand.New(rand.NewSource(*seed))
+	accounts := 0
+	newTx := func() *Transaction {
+		// 每笔交易一个账户，避免 nonce 顺序影响与全量排序实现的比较
+		accounts++
+		tx := &Transaction{
+			Sender:   fmt.Sprint(accounts),
+			GasPrice: big.NewInt(1e9 + rng.Int63n(200e9)),
+			GasUsed:  big.NewInt(21000 + rng.Int63n(30000)),
+			isCTX:    rng.Float64() < *ctxRatio,
+		}
+		tx.Relayed = tx.isCTX && rng.Intn(2) == 0
+		return tx
+	}
+	// 每轮的 Tax/Subsidy 在 ±2e14 内随机，保证每轮都有交易因收益为负被留下
+	taxPools := make([]*TaxPool, *rounds)
+	for i := range taxPools {
+		tp := NewTaxPool(DefaultConfig(), nil)
+		tp.Tax = big.NewInt(rng.Int63n(4e14) - 2e14)
+		tp.Subsidy = big.NewInt(rng.Int63n(4e14) - 2e14)
+		taxPools[i] = tp
+	}
+	// 预先生成所有轮次要补进池子的交易，两种实现拿到完全相同的输入
+	refills := make([][]*Transaction, *rounds)
+	refills[0] = make([]*Transaction, *poolSize)
+	for i := range refills[0] {
+		refills[0][i] = newTx()
+	}
+	for r := 1; r < *rounds; r++ {
+		refills[r] = make([]*Transaction, *blockSize)
+		for i := range refills[r] {
+			refills[r][i] = newTx()
+		}
+	}
+
+	type packer interface {
+		AddTxs2Pool(txs []*Transaction)
+		PackTxs(max_txs uint64, tp *TaxPool) []*Transaction
+	}
+	run := func(name string, p packer) [][]*Transaction {
+		var packTime time.Duration
+		var before, after runtime.MemStats
+		results := make([][]*Transaction, *rounds)
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		for r := 0; r < *rounds; r++ {
+			p.AddTxs2Pool(refills[r])
+			begin := time.Now()
+			results[r] = p.PackTxs(uint64(*blockSize), taxPools[r])
+			packTime += time.Since(begin)
+		}
+		runtime.ReadMemStats(&after)
+		fmt.Printf("%-10s %12.3f ms/pack %14d B/op %10d allocs/op\n", name,
+			float64(packTime.Microseconds())/1000/float64(*rounds),
+			(after.TotalAlloc-before.TotalAlloc)/uint64(*rounds),
+			(after.Mallocs-before.Mallocs)/uint64(*rounds))
+		return results
+	}
+
+	fmt.Printf("交易池 %d 笔，区块大小 %d，%d 轮（B/op、allocs/op 含补池开销）\n", *poolSize, *blockSize, *rounds)
+	heapResults := run("heap", NewTxPool())
+	sortResults := run("sort", newSortTxPool())
+
+	// 收益相同的交易谁先被打包两种实现可能不同，所以逐轮比较打包交易的收益序列
+	for r := range heapResults {
+		a, b := profits(heapResults[r], taxPools[r]), profits(sortResults[r], taxPools[r])
+		if len(a) != len(b) {
+			fmt.Printf("❌ 第 %d 轮打包笔数不一致: heap %d, sort %d\n", r, len(a), len(b))
+			os.Exit(1)
+		}
+		for i := range a {
+			if a[i].Cmp(b[i]) != 0 {
+				fmt.Printf("❌ 第 %d 轮第 %d 笔收益不一致: heap %s, sort %s\n", r, i, a[i], b[i])
+				os.Exit(1)
+			}
+		}
+	}
+	fmt.Println("✅ 两种实现每轮打包的交易收益序列一致")
+}
+
+// profits 交易在 tp 下的实际收益，降序
+func profits(txs []*Transaction, tp *TaxPool) []*big.Int {
+	out := make([]*big.Int, len(txs))
+	for i, tx := range txs {
+		out[i] = txProfit(tx, tp)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Cmp(out[j]) > 0 })
+	return out
+}
+
+// txProfit 交易加了税/补贴后矿工的实际收益
+func txProfit(tx *Transaction, tp *TaxPool) *big.Int {
+	p := txKey(tx, nil)
+	if tx.isCTX {
+		return p.Add(p, tp.Subsidy)
+	}
+	return p.Sub(p, tp.Tax)
+}
+
+// sortTxPool 原先的交易池实现：每次打包都对全池重新计算收益并全量排序，仅用于性能对比
+type sortTxPool struct {
+	TxQueue []*Transaction
+}
+
+func newSortTxPool() *sortTxPool {
+	return &sortTxPool{}
+}
+
+func (txpool *sortTxPool) AddTxs2Pool(txs []*Transaction) {
+	txpool.TxQueue = append(txpool.TxQueue, txs...)
+}
+
+func (txpool *sortTxPool) PackTxs(max_txs uint64, tp *TaxPool) []*Transaction {
+	// 分开加了税/补贴后为负和为正的交易，只打包收益为正的交易
+	positiveTxs := make([]*Transaction, 0, len(txpool.TxQueue))
+	for _, tx := range txpool.TxQueue {
+		fee := new(big.Int).Mul(tx.GasPrice, tx.GasUsed)
+		if tx.isCTX {
+			fee = new(big.Int).Div(fee, big.NewInt(2))
+			fee.Add(fee, tp.Subsidy)
+		} else {
+			fee.Sub(fee, tp.Tax)
+		}
+		if fee.Sign() >= 0 {
+			positiveTxs = append(positiveTxs, tx)
+		}
+	}
+
+	// 按手续费排序
+	sort.Slice(positiveTxs, func(i, j int) bool {
+		priceI := new(big.Int).Mul(positiveTxs[i].GasPrice, positiveTxs[i].GasUsed)
+		if positiveTxs[i].isCTX {
+			priceI.Div(priceI, big.NewInt(2))
+			priceI.Add(priceI, tp.Subsidy)
+		} else {
+			priceI.Sub(priceI, tp.Tax)
+		}
+
+		priceJ := new(big.Int).Mul(positiveTxs[j].GasPrice, positiveTxs[j].GasUsed)
+		if positiveTxs[j].isCTX {
+			priceJ.Div(priceJ, big.NewInt(2))
+			priceJ.Add(priceJ, tp.Subsidy)
+		} else {
+			priceJ.Sub(priceJ, tp.Tax)
+		}
+		return priceI.Cmp(priceJ) > 0
+	})
+
+	// 最多只打包blocksize个交易
+	if uint64(len(positiveTxs)) > max_txs {
+		positiveTxs = positiveTxs[:max_txs]
+	}
+
+	packedMap := make(map[*Transaction]bool)
+	for _, tx := range positiveTxs {
+		packedMap[tx] = true
+	}
+	remaining := make([]*Transaction, 0, len(txpool.TxQueue))
+	for _, tx := range txpool.TxQueue {
+		if !packedMap[tx] {
+			remaining = append(remaining, tx)
+		}
+	}
+	txpool.TxQueue = remaining
+	return positiveTxs
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 跨分片交易的处理方式
+const (
+	CrossShardRelay  = "relay"  // Monoxide：源分片打包 relay1，目的分片打包 relay2
+	CrossShardBroker = "broker" // BrokerChain：经 broker 账户拆成两笔片内交易 broker1、broker2
+)
+
+// Brokers broker 账户，按地址文件中的顺序。broker 在每个分片都有账户，与 broker 之间的交易都是片内交易
+type Brokers struct {
+	addrs []Address
+	set   map[Address]bool
+}
+
+// Has addr 是否为 broker，b 为 nil（relay 模式）时恒为 false
+func (b *Brokers) Has(addr Address) bool {
+	return b != nil && b.set[addr]
+}
+
+// For 经手 sender 的跨分片交易的 broker，按 sender 地址在各 broker 间均匀分配，同一 sender 总经同一个 broker
+func (b *Brokers) For(sender Address) Address {
+	return b.addrs[Addr2Shard(sender, len(b.addrs))]
+}
+
+func (b *Brokers) Len() int {
+	return len(b.addrs)
+}
+
+// LoadBrokers 读入 broker 地址文件的前 n 行（每行一个地址，可带 0x 前缀），与 figurePlot 的 load_brokers 一致
+func LoadBrokers(path string, n int) (*Brokers, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	brokers := &Brokers{set: make(map[Address]bool)}
+	scanner := bufio.NewScanner(f)
+	for line := 0; line < n && scanner.Scan(); line++ {
+		addr := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(scanner.Text())), "0x")
+		if addr != "" && !brokers.set[addr] {
+			brokers.set[addr] = true
+			brokers.addrs = append(brokers.addrs, addr)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if brokers.Len() == 0 {
+		return nil, fmt.Errorf("broker 文件 %s 中没有地址", path)
+	}
+	return brokers, nil
+}
+
+// routeTx 判断交易是否跨分片，并给出最先处理它的分片。
+// relay 模式（brokers 为 nil）下收发双方不在同一分片即为跨分片交易，由 sender 所在分片先处理；
+// broker 模式下一方是 broker 的交易是片内交易，由另一方所在分片处理，其余跨分片交易经 broker 拆成两笔片内交易：
+// broker1（sender -> broker）在 sender 所在分片，上链后 broker 发出 broker2（broker -> recipient）到 recipient 所在分片，
+// 此时返回经手的 broker
+func routeTx(tx *Transaction, shardNum int, brokers *Brokers) (isCTX bool, sid int, broker Address) {
+	switch {
+	case brokers == nil:
+		return isCtx(tx.Sender, tx.Recipient, shardNum), Addr2Shard(tx.Sender, shardNum), ""
+	case brokers.Has(tx.Sender):
+		return false, Addr2Shard(tx.Recipient, shardNum), ""
+	case brokers.Has(tx.Recipient):
+		return false, Addr2Shard(tx.Sender, shardNum), ""
+	case isCtx(tx.Sender, tx.Recipient, shardNum):
+		return false, Addr2Shard(tx.Sender, shardNum), brokers.For(tx.Sender)
+	default:
+		return false, Addr2Shard(tx.Sender, shardNum), ""
+	}
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// CheckpointConfig 定期把完整的模拟状态保存到文件，之后可用 taxsim resume 继续、taxsim fork 分叉
+type CheckpointConfig struct {
+	Every int    `json:"every"` // 每出多少轮区块保存一次，运行结束时也保存一次，0 为不保存
+	Path  string `json:"path"`  // 保存路径，可用 {round} 表示出块轮数，空为 {outputDir}/checkpoint.gob（每次覆盖）
+}
+
+// checkpointVersion checkpoint 文件格式版本，格式不兼容地变化时递增
+const checkpointVersion = 1
+
+// Checkpoint 一次出块轮结束时的完整模拟状态：各分片的交易池、税池、区块高度，交易来源的读取进度和各账户的 nonce，
+// 在途的 relay2 交易、调度器中待执行的事件和随机数源的进度
+type Checkpoint struct {
+	Version     int
+	Config      []byte // 运行配置的 JSON
+	Now         time.Time
+	Rounds      int
+	BatchCount  int
+	SrcFinished bool
+	NextInject  time.Time
+	NextBlock   time.Time
+	RngDraws    uint64
+	Source      SourceState
+	Nonces      map[nonceKey]uint64
+	Shards      []ShardState
+	Relays      []relayState
+}
+
+// ShardState 一个分片的状态。TaxPolicy、MinerStrategy 实现了 Stateful 时连同名字一起保存其内部状态
+type ShardState struct {
+	BlockNum    int
+	PrevEnd     time.Time
+	BaseFee     *big.Int
+	Burned      *big.Int
+	TaxPool     *TaxPool
+	Pool        poolState
+	Policy      string
+	PolicyState []byte
+	Miner       string
+	MinerState  []byte
+}
+
+// Stateful 带内部状态的 TaxPolicy / MinerStrategy 实现此接口，状态随 checkpoint 保存，恢复时同名的实例载入
+type Stateful interface {
+	SaveState() ([]byte, error)
+	LoadState(data []byte) error
+}
+
+// txState gob 不编码 Transaction 未导出的 isCTX，单独保存
+type txState struct {
+	Tx    *Transaction
+	IsCTX bool
+}
+
+type relayState struct {
+	At       time.Time
+	From, To uint64
+	Txs      []txState
+}
+
+// poolState 交易池的内容和计数，各堆在恢复时按新配置的容量、存活时间重建
+type poolState struct {
+	Entries   []entryState
+	Accounts  map[Address]accountState
+	Seq       int64
+	HeadSeq   int64
+	Drops     DropCounts
+	Replaced  int
+	Cancelled int
+}
+
+type entryState struct {
+	Tx           txState
+	Seq          int64
+	Added        time.Time
+	Starved      int64
+	StarvedSince time.Time
+}
+
+type accountState struct {
+	Next, End uint64
+}
+
+// countingSource 记录取数次数的随机数源：math/rand 的状态无法导出，checkpoint 只保存种子和取数次数，恢复时重放
+type countingSource struct {
+	src   rand.Source64
+	draws uint64
+}
+
+func newCountingSource(seed int64) *countingSource {
+	return &countingSource{src: rand.NewSource(seed).(rand.Source64)}
+}
+
+func (s *countingSource) Int63() int64 {
+	s.draws++
+	return s.src.Int63()
+}
+
+func (s *countingSource) Uint64() uint64 {
+	s.draws++
+	return s.src.Uint64()
+}
+
+func (s *countingSource) Seed(seed int64) {
+	s.src.Seed(seed)
+	s.draws = 0
+}
+
+// skipTo 重放到第 n 次取数之后的状态
+func (s *countingSource) skipTo(n uint64) error {
+	if n < s.draws {
+		return fmt.Errorf("随机数源已取数 %d 次，无法回到 %d", s.draws, n)
+	}
+	for s.draws < n {
+		s.Int63()
+	}
+	return nil
+}
+
+func encodeGob(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeGob(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// snapshot 导出交易池状态
+func (txpool *TxPool) snapshot() poolState {
+	txpool.lock.Lock()
+	defer txpool.lock.Unlock()
+	ps := poolState{
+		Accounts:  make(map[Address]accountState, len(txpool.accounts)),
+		Seq:       txpool.seq,
+		HeadSeq:   txpool.headSeq,
+		Drops:     txpool.drops,
+		Replaced:  txpool.replaced,
+		Cancelled: txpool.cancelled,
+	}
+	add := func(e *poolEntry) {
+		ps.Entries = append(ps.Entries, entryState{
+			Tx:           txState{Tx: e.tx, IsCTX: e.tx.isCTX},
+			Seq:          e.seq,
+			Added:        e.added,
+			Starved:      e.starved,
+			StarvedSince: e.starvedSince,
+		})
+	}
+	for _, h := range []*entryHeap{&txpool.itxs, &txpool.ctxs} {
+		for _, e := range h.items {
+			if e.acc == nil {
+				add(e)
+			}
+		}
+	}
+	for sender, acc := range txpool.accounts {
+		ps.Accounts[sender] = accountState{Next: acc.next, End: acc.end}
+		for _, e := range acc.txs {
+			add(e)
+		}
+	}
+	return ps
+}
+
+// restore 把空交易池恢复到 ps 的状态。各堆的比较都以进池顺序兜底，重建后打包、驱逐的顺序与保存前一致
+func (txpool *TxPool) restore(ps poolState) {
+	txpool.lock.Lock()
+	defer txpool.lock.Unlock()
+	txpool.seq, txpool.headSeq = ps.Seq, ps.HeadSeq
+	txpool.drops, txpool.replaced, txpool.cancelled = ps.Drops, ps.Replaced, ps.Cancelled
+	for sender, as := range ps.Accounts {
+		txpool.accounts[sender] = &account{next: as.Next, end: as.End, txs: make(map[uint64]*poolEntry)}
+	}
+	for _, es := range ps.Entries {
+		tx := es.Tx.Tx
+		tx.isCTX = es.Tx.IsCTX
+		e := txpool.newEntry(tx, es.Seq, es.Added)
+		e.starved, e.starvedSince = es.Starved, es.StarvedSince
+		txpool.setKey(e) // aging 加成取决于 starved
+		if e.starved > 0 {
+			txpool.starved = append(txpool.starved, e)
+		}
+		if tx.Relayed {
+			// 尚未打包的 relay2 的 ShardID 仍是源分片
+			txpool.addRelay(e, tx.ShardID)
+			continue
+		}
+		acc := txpool.accounts[tx.Sender]
+		e.acc = acc
+		acc.txs[tx.Nonce] = e
+		txpool.queueLen++
+		txpool.track(e)
+		if tx.Nonce >= acc.end {
+			txpool.queuedLen++
+		} else if tx.Nonce == acc.next {
+			txpool.compete(e)
+		}
+	}
+}
+
+// snapshot 导出分片状态，须在两次出块之间调用
+func (s *Shard) snapshot() (ShardState, error) {
+	st := ShardState{
+		BlockNum: s.blockNum,
+		PrevEnd:  s.prevEnd,
+		BaseFee:  s.baseFee,
+		Burned:   s.burned,
+		TaxPool:  s.TaxPool,
+		Pool:     s.TxPool.snapshot(),
+		Policy:   s.Policy.Name(),
+		Miner:    s.Miner.Name(),
+	}
+	var err error
+	if sp, ok := s.Policy.(Stateful); ok {
+		if st.PolicyState, err = sp.SaveState(); err != nil {
+			return st, fmt.Errorf("policy %s: %v", st.Policy, err)
+		}
+	}
+	if sm, ok := s.Miner.(Stateful); ok {
+		if st.MinerState, err = sm.SaveState(); err != nil {
+			return st, fmt.Errorf("miner %s: %v", st.Miner, err)
+		}
+	}
+	return st, nil
+}
+
+// restore 把新建的分片恢复到 st 的状态。算法、策略换了（fork）时新实例从初始状态开始
+func (s *Shard) restore(st ShardState) error {
+	s.blockNum, s.prevEnd, s.burned = st.BlockNum, st.PrevEnd, st.Burned
+	tp := st.TaxPool
+	tp.cfg, tp.logChan = s.cfg, s.logChan
+	s.TaxPool = tp
+	s.TxPool.tp = tp
+	if st.BaseFee != nil {
+		s.baseFee = st.BaseFee
+		s.TxPool.SetBaseFee(s.baseFee)
+	}
+	s.TxPool.restore(st.Pool)
+	if sp, ok := s.Policy.(Stateful); ok && st.Policy == s.Policy.Name() && st.PolicyState != nil {
+		if err := sp.LoadState(st.PolicyState); err != nil {
+			return fmt.Errorf("policy %s: %v", st.Policy, err)
+		}
+	}
+	if sm, ok := s.Miner.(Stateful); ok && st.Miner == s.Miner.Name() && st.MinerState != nil {
+		if err := sm.LoadState(st.MinerState); err != nil {
+			return fmt.Errorf("miner %s: %v", st.Miner, err)
+		}
+	}
+	return nil
+}
+
+// saveCheckpoint 在一轮出块结束后保存状态，失败只记日志、不中断模拟
+func (r *simRun) saveCheckpoint() {
+	path := r.cfg.CheckpointPath(r.rounds)
+	if err := r.writeCheckpoint(path); err != nil {
+		r.sim.logChan <- fmt.Sprintf("Checkpoint=> 第 %d 轮出块后保存 %s 失败: %v", r.rounds, path, err)
+		return
+	}
+	r.sim.logChan <- fmt.Sprintf("Checkpoint=> 第 %d 轮出块后保存到 %s", r.rounds, path)
+}
+
+func (r *simRun) writeCheckpoint(path string) error {
+	cfgJSON, err := json.Marshal(r.cfg)
+	if err != nil {
+		return err
+	}
+	ck := Checkpoint{
+		Version:     checkpointVersion,
+		Config:      cfgJSON,
+		Now:         r.sched.Now(),
+		Rounds:      r.rounds,
+		BatchCount:  r.batchCount,
+		SrcFinished: r.srcFinished,
+		NextInject:  r.nextInject,
+		NextBlock:   r.nextBlock,
+		RngDraws:    r.rngSrc.draws,
+		Source:      r.src.State(),
+		Nonces:      r.nonces,
+	}
+	for _, s := range r.shards {
+		st, err := s.snapshot()
+		if err != nil {
+			return fmt.Errorf("shard %d: %v", s.ID, err)
+		}
+		ck.Shards = append(ck.Shards, st)
+	}
+	for _, d := range r.relays {
+		rs := relayState{At: d.At, From: d.From, To: d.To}
+		for _, tx := range d.Txs {
+			rs.Txs = append(rs.Txs, txState{Tx: tx, IsCTX: tx.isCTX})
+		}
+		ck.Relays = append(ck.Relays, rs)
+	}
+
+	// 先写临时文件再改名，中途被打断也不会留下半个 checkpoint
+	file, err := createFile(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(file).Encode(&ck); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// restore 把新建的运行恢复到 ck 的状态，事件由调用方按恢复出的时刻重新调度
+func (r *simRun) restore(ck *Checkpoint) error {
+	if len(ck.Shards) != len(r.shards) {
+		return fmt.Errorf("checkpoint 有 %d 个分片，当前配置 %d 个", len(ck.Shards), len(r.shards))
+	}
+	if err := r.src.Restore(ck.Source); err != nil {
+		return fmt.Errorf("交易来源: %v", err)
+	}
+	if err := r.rngSrc.skipTo(ck.RngDraws); err != nil {
+		return err
+	}
+	for i, s := range r.shards {
+		if err := s.restore(ck.Shards[i]); err != nil {
+			return fmt.Errorf("shard %d: %v", i, err)
+		}
+	}
+	for _, rs := range ck.Relays {
+		d := &relayDelivery{At: rs.At, From: rs.From, To: rs.To}
+		for _, ts := range rs.Txs {
+			ts.Tx.isCTX = ts.IsCTX
+			d.Txs = append(d.Txs, ts.Tx)
+		}
+		r.relays = append(r.relays, d)
+	}
+	r.sched = NewScheduler(ck.Now)
+	r.rounds, r.batchCount, r.srcFinished = ck.Rounds, ck.BatchCount, ck.SrcFinished
+	r.nextInject, r.nextBlock = ck.NextInject, ck.NextBlock
+	if ck.Nonces != nil { // gob 不保存空 map
+		r.nonces = ck.Nonces
+	}
+	return nil
+}
+
+// LoadCheckpoint 读入 checkpoint 文件
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ck Checkpoint
+	if err := decodeGob(data, &ck); err != nil {
+		return nil, fmt.Errorf("解析 checkpoint %s 失败: %v", path, err)
+	}
+	if ck.Version != checkpointVersion {
+		return nil, fmt.Errorf("checkpoint %s 版本 %d 不受支持，当前版本 %d", path, ck.Version, checkpointVersion)
+	}
+	return &ck, nil
+}
+
+// RunConfig checkpoint 保存时的运行配置，较早保存的配置中没有的新字段取默认值
+func (ck *Checkpoint) RunConfig() (*Config, error) {
+	cfg := DefaultConfig()
+	if err := decodeConfig(ck.Config, cfg); err != nil {
+		return nil, fmt.Errorf("checkpoint 中的配置: %v", err)
+	}
+	return cfg, nil
+}
+
+// CheckpointPath 第 rounds 轮出块后 checkpoint 的保存路径
+func (c *Config) CheckpointPath(rounds int) string {
+	path := c.Checkpoint.Path
+	if path == "" {
+		path = filepath.Join(c.OutputDir, "checkpoint.gob")
+	}
+	return strings.ReplaceAll(path, "{round}", fmt.Sprint(rounds))
+}
+
+// checkResumable 检查 c 能否从以 base 运行保存的 checkpoint 继续：决定状态结构和交易流的参数必须一致，
+// 调节算法、矿工策略、出块数上限、输出等可以改
+func (c *Config) checkResumable(base *Config) error {
+	type structural struct {
+		ShardNum         int
+		TxsCsvPath       string
+		DataTotalNum     int
+		GlobalBatchSz    int
+		Source           SourceConfig
+		CrossShard       string
+		BrokerFile       string
+		BrokerNum        int
+		FeeModel         string
+		InjectIntervalMs int64
+		Seed             int64
+	}
+	pick := func(c *Config) structural {
+		return structural{c.ShardNum, c.TxsCsvPath, c.DataTotalNum, c.GlobalBatchSz, c.Source,
+			c.CrossShard, c.BrokerFile, c.BrokerNum, c.FeeModel, c.InjectIntervalMs, c.Seed}
+	}
+	a, _ := json.Marshal(pick(base))
+	b, _ := json.Marshal(pick(c))
+	if !bytes.Equal(a, b) {
+		return fmt.Errorf("分片数、数据来源、跨分片机制、费用模型、注入间隔和种子须与 checkpoint 一致\n  checkpoint: %s\n  当前:       %s", a, b)
+	}
+	return nil
+}
+
+// LoadResumeConfig 解析 taxsim resume 的命令行：以 -checkpoint 中保存的配置为基础，命令行中显式给出的参数覆盖
+func LoadResumeConfig(args []string) (*Config, *Checkpoint, error) {
+	var ckPath string
+	probe := flag.NewFlagSet("taxsim resume", flag.ContinueOnError)
+	probe.StringVar(&ckPath, "checkpoint", "", "checkpoint 文件路径（必填）")
+	DefaultConfig().bindFlags(probe)
+	if err := probe.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if ckPath == "" {
+		probe.Usage()
+		return nil, nil, errors.New("需要 -checkpoint")
+	}
+
+	ck, err := LoadCheckpoint(ckPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	base, err := ck.RunConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, _ := ck.RunConfig()
+	fs := flag.NewFlagSet("taxsim resume", flag.ContinueOnError)
+	fs.String("checkpoint", "", "checkpoint 文件路径（必填）")
+	cfg.bindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if err := errors.Join(cfg.Validate(), cfg.checkResumable(base)); err != nil {
+		return nil, nil, err
+	}
+	return cfg, ck, nil
+}
+
+// runResume taxsim resume：从 checkpoint 继续一次运行
+func runResume(args []string) {
+	cfg, ck, err := LoadResumeConfig(args)
+	if err != nil {
+		log.Fatalf("配置错误: %v", err)
+	}
+	fmt.Printf("从 checkpoint 继续（已出块 %d 轮），运行配置:\n%s\n", ck.Rounds, cfg)
+	if err := NewSim(cfg).ResumeFrom(ck).Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runFork taxsim fork：从同一个 checkpoint 出发，按 -grid 中的参数组合并行跑多个分支，
+// grid 的写法与 taxsim sweep 相同，base 叠加在 checkpoint 的配置上
+func runFork(args []string) {
+	fs := flag.NewFlagSet("taxsim fork", flag.ExitOnError)
+	ckPath := fs.String("checkpoint", "", "checkpoint 文件路径（必填）")
+	specPath := fs.String("grid", "", "分支参数 JSON 路径（必填），格式同 sweep")
+	workers := fs.Int("workers", runtime.NumCPU(), "并行运行数")
+	outDir := fs.String("out", filepath.Join("forks", runTimestamp()), "输出目录，每个分支写到其下的子目录")
+	fs.Parse(args)
+	if *ckPath == "" || *specPath == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ck, err := LoadCheckpoint(*ckPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	base, err := ck.RunConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	runs, err := loadSweepOn(*specPath, *outDir, func() *Config {
+		cfg, _ := ck.RunConfig()
+		return cfg
+	})
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for i := range runs {
+		if err := runs[i].Cfg.checkResumable(base); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", runs[i].Label, err))
+		}
+		runs[i].Checkpoint = *ckPath
+	}
+	if err := errors.Join(errs...); err != nil {
+		fmt.Fprintf(os.Stderr, "分支参数配置错误: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("从 checkpoint %s（已出块 %d 轮）分叉：共 %d 个分支，%d 个并行，输出到 %s\n", *ckPath, ck.Rounds, len(runs), *workers, *outDir)
+
+	results := RunSweep(runs, *workers)
+	indexPath := filepath.Join(*outDir, "index.csv")
+	if err := writeSweepIndex(indexPath, runs, results); err != nil {
+		fmt.Fprintf(os.Stderr, "写出 %s 失败: %v\n", indexPath, err)
+		os.Exit(1)
+	}
+	fmt.Printf("分叉运行完成，结果汇总见 %s\n", indexPath)
+	for _, r := range results {
+		if r.Err != nil {
+			os.Exit(1)
+		}
+	}
+}
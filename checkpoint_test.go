@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// addr 形如地址的十六进制串，末尾决定所在分片
+func addr(prefix, i int) Address {
+	return fmt.Sprintf("%x%015x", prefix, i)
+}
+
+// fillShard 向分片注入第 batch 批片内、跨分片交易（含 nonce 空缺）和来自 1 号分片的 relay2 交易
+func fillShard(s *Shard, t0 time.Time, batch int) {
+	var txs, relays []*Transaction
+	for i := batch * 120; i < (batch+1)*120; i++ {
+		fee := int64(1 + (i*7919)%97)
+		tx := &Transaction{
+			Sender: addr(0xa, i%30), Recipient: addr(0xb, i), Nonce: uint64(i / 30),
+			Value: big.NewInt(0), GasPrice: big.NewInt(fee * 1e9), GasUsed: big.NewInt(21000),
+			MaxFeePerGas: big.NewInt(fee * 1e9), MaxPriorityFeePerGas: big.NewInt(fee * 1e8),
+			TxHash: []byte(fmt.Sprintf("tx%d", i)), Time: t0.Add(time.Duration(i) * time.Millisecond),
+			isCTX: i%3 == 0,
+		}
+		if i%30 == 7 && tx.Nonce == 1 { // 留下 nonce 空缺
+			tx.Nonce = 5
+		}
+		txs = append(txs, tx)
+		if i%4 == 0 {
+			relays = append(relays, &Transaction{
+				Sender: addr(0xc, i), Recipient: addr(0xb, i), Value: big.NewInt(0),
+				GasPrice: big.NewInt(fee * 1e9), GasUsed: big.NewInt(21000), TxHash: []byte(fmt.Sprintf("relay%d", i)),
+				Time: t0, ShardID: 1, isCTX: true, Relayed: true, Relay1Time: t0, Relay1Subsidy: big.NewInt(0),
+			})
+		}
+	}
+	s.TxPool.AddTxs2Pool(txs)
+	s.TxPool.AddRelayTxs(1, relays)
+}
+
+// 保存分片状态、经 gob 编解码恢复到新分片后，下一块与不中断时完全相同
+func TestCheckpointNextBlockIdentical(t *testing.T) {
+	cases := []struct {
+		name  string
+		setup func(cfg *Config)
+		tax   int64 // 初始 Tax，让低手续费的 itx 挨饿
+	}{
+		{"默认", func(cfg *Config) {}, 0},
+		{"挨饿与 aging", func(cfg *Config) {
+			cfg.Policy = "v3"
+			cfg.AgingStep = 1e12
+		}, 40e9 * 21000},
+		{"容量与存活时间", func(cfg *Config) {
+			cfg.PoolCapacity = 80
+			cfg.TxTTLMs = 2500
+		}, 0},
+		{"EIP-1559 按 gas 打包", func(cfg *Config) {
+			cfg.FeeModel = FeeModelEIP1559
+			cfg.BlockGasLimit = 21000 * 20
+		}, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.BlockSize = 16
+			cfg.TxDetails = true
+			c.setup(cfg)
+			logChan := make(chan string)
+			go func() {
+				for range logChan {
+				}
+			}()
+			defer close(logChan)
+
+			t0 := time.Unix(1700000000, 0)
+			interval := time.Second
+			orig := NewShard(0, cfg, logChan)
+			orig.TaxPool.Tax = big.NewInt(c.tax)
+			fillShard(orig, t0, 0)
+			for b := 1; b <= 2; b++ {
+				orig.ProduceBlock(t0.Add(time.Duration(b) * interval))
+				orig.TakeRelayTxs()
+				orig.TakeTxDetails()
+			}
+
+			st, err := orig.snapshot()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := encodeGob(st)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded ShardState
+			if err := decodeGob(data, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			restored := NewShard(0, cfg, logChan)
+			if err := restored.restore(decoded); err != nil {
+				t.Fatal(err)
+			}
+
+			// 恢复后继续有交易到达，覆盖 seq 和账户的 nonce 进度
+			fillShard(orig, t0.Add(2*interval), 1)
+			fillShard(restored, t0.Add(2*interval), 1)
+			for b := 3; b <= 6; b++ {
+				now := t0.Add(time.Duration(b) * interval)
+				want, wantOK := orig.ProduceBlock(now)
+				got, gotOK := restored.ProduceBlock(now)
+				if gotOK != wantOK || !reflect.DeepEqual(got, want) {
+					t.Fatalf("第 %d 块统计不同:\n got  %+v\n want %+v", b, got, want)
+				}
+				if g, w := restored.TakeTxDetails(), orig.TakeTxDetails(); !reflect.DeepEqual(g, w) {
+					t.Fatalf("第 %d 块上链交易不同: got %d 笔, want %d 笔", b, len(g), len(w))
+				}
+				if g, w := restored.TakeRelayTxs(), orig.TakeRelayTxs(); !reflect.DeepEqual(g, w) {
+					t.Fatalf("第 %d 块发出的 relay2 不同: got %d 笔, want %d 笔", b, len(g), len(w))
+				}
+			}
+		})
+	}
+}
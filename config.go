@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config 一次模拟运行的全部参数，可由 JSON 配置文件给出，命令行参数覆盖同名项
+type Config struct {
+	ShardNum      int    `json:"shardNum"`
+	TxsCsvPath    string `json:"txsCsvPath"`
+	DataTotalNum  int    `json:"dataTotalNum"`  // 顺序读取时最多读入的交易数
+	BlockSize     int    `json:"blockSize"`     // 每个区块最多打包的交易数
+	BlockGasLimit int64  `json:"blockGasLimit"` // 每个区块的 gas 上限，大于 0 时按 gas 填满区块、不再限制交易数
+	GlobalBatchSz int    `json:"globalBatchSz"` // 从 CSV 一次拉的交易数
+	MaxBlocks     int    `json:"maxBlocks"`     // 每个分片出满 MaxBlocks 个区块后停止
+	PoolCapacity  int    `json:"poolCapacity"`  // 每个分片交易池可容纳的交易数（不含 relay2），满了驱逐收益最低的，0 为不限
+	TxTTLMs       int64  `json:"txTTLMs"`       // 交易提出后在池中的存活时间，超过即丢弃，0 为不过期
+
+	// 提价替换与取消
+	PriceBumpPercent int64          `json:"priceBumpPercent"` // 替换池中同 nonce 交易所需的最低 GasPrice 涨幅（%）
+	Resubmit         ResubmitConfig `json:"resubmit"`         // 用户对卡住交易的提价、取消行为
+	AgingStep        int64          `json:"agingStep"`        // 被税挤出的交易每多挨饿一个区块，排序用的收益提高多少 (wei)，0 为不启用
+
+	// 费用模型
+	FeeModel  string          `json:"feeModel"`  // legacy | eip1559
+	FeeMarket FeeMarketConfig `json:"feeMarket"` // eip1559 模型参数
+
+	Source SourceConfig `json:"source"` // 交易注入方式
+
+	// 跨分片机制
+	CrossShard string `json:"crossShard"` // relay | broker
+	BrokerFile string `json:"brokerFile"` // broker 模式下的 broker 地址文件，每行一个地址
+	BrokerNum  int    `json:"brokerNum"`  // 取 broker 文件的前 BrokerNum 个地址
+
+	// 税池调节参数
+	Delta               int64                   `json:"delta"`               // tax & subsidy 调整步长
+	EpsilonDelay        int64                   `json:"epsilonDelay"`        // 时延平衡容忍区间
+	EpsilonBalance      int64                   `json:"epsilonBalance"`      // 税池平衡容忍区间
+	EpsilonDeltaBalance int64                   `json:"epsilonDeltaBalance"` // 税池变化量容忍区间
+	Policy              string                  `json:"policy"`              // 税池调节算法名，见 TaxPolicyNames
+	PolicyParams        PolicyParams            `json:"policyParams"`        // 算法参数，如 v1 的 a、b；v3.x 可单独覆盖 delta 和各 epsilon
+	StepRule            StepRuleConfig          `json:"stepRule"`            // v3.x 步长 Δ 的自适应规则
+	Signal              SignalConfig            `json:"signal"`              // 各算法默认的手续费信号估计方式，Diff 由此计算
+	PolicySignals       map[string]SignalConfig `json:"policySignals"`       // 按算法名单独指定的信号估计方式
+	SolverBenchmark     bool                    `json:"solverBenchmark"`     // 每块另按交易池内容求解出清的 Tax/Subsidy，与算法给出的值并列输出
+	Solvency            SolvencyConfig          `json:"solvency"`            // 税池余额下限、补贴配给和 Tax/Subsidy 的界限
+
+	// 矿工打包策略
+	Miner       MinerConfig         `json:"miner"`       // 各分片默认的矿工策略
+	ShardMiners map[int]MinerConfig `json:"shardMiners"` // 按分片号单独指定的矿工策略
+
+	// 模拟时钟
+	BlockIntervalMs  int64 `json:"blockIntervalMs"`  // 各分片出块间隔
+	InjectIntervalMs int64 `json:"injectIntervalMs"` // 每隔多久注入一批交易
+	RelayDelayMs     int64 `json:"relayDelayMs"`     // relay2 交易送达目的分片的网络时延
+	Seed             int64 `json:"seed"`             // 随机数种子
+
+	// 输出
+	OutputDir string       `json:"outputDir"`
+	LogPath   string       `json:"logPath"`
+	TxDetails bool         `json:"txDetails"` // 是否逐笔写出 {outputDir}/Tx_Details.csv
+	Sinks     []SinkConfig `json:"sinks"`     // 出块统计的输出目标，可同时启用多个
+
+	Checkpoint CheckpointConfig `json:"checkpoint"` // 定期保存完整模拟状态
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		ShardNum:      4,
+		TxsCsvPath:    "",      // 数据集不随仓库分发，须用 -csv 或配置文件指定
+		DataTotalNum:  3607054, // 1100k txsCsv数据条数
+		BlockSize:     2000,
+		GlobalBatchSz: 10000, // 从 CSV 一次拉 10000 笔
+		MaxBlocks:     600,
+
+		Source: DefaultSourceConfig(),
+
+		PriceBumpPercent: DefaultPriceBump,
+		Resubmit:         DefaultResubmitConfig(),
+
+		FeeModel:  FeeModelLegacy,
+		FeeMarket: DefaultFeeMarketConfig(),
+
+		CrossShard: CrossShardRelay,
+		BrokerFile: "./broker/broker",
+		BrokerNum:  10,
+
+		Delta:               100000000000,       // 10^11
+		EpsilonDelay:        10000000000000,     // 10^13
+		EpsilonBalance:      100000000000000000, // 10^17
+		EpsilonDeltaBalance: 10000000000000000,  // 10^16
+		Policy:              "v3.4",
+		PolicyParams:        PolicyParams{},
+		StepRule:            DefaultStepRuleConfig(),
+		Signal:              DefaultSignalConfig(),
+
+		Miner: MinerConfig{Strategy: "greedy", Params: PolicyParams{}},
+
+		BlockIntervalMs:  5000,
+		InjectIntervalMs: 5000,
+		RelayDelayMs:     100,
+		Seed:             1,
+
+		OutputDir: "outputCSV",
+		LogPath:   "exp.log",
+		Sinks:     DefaultSinkConfigs(),
+	}
+}
+
+func (c *Config) BlockInterval() time.Duration {
+	return time.Duration(c.BlockIntervalMs) * time.Millisecond
+}
+
+func (c *Config) InjectInterval() time.Duration {
+	return time.Duration(c.InjectIntervalMs) * time.Millisecond
+}
+
+func (c *Config) RelayDelay() time.Duration {
+	return time.Duration(c.RelayDelayMs) * time.Millisecond
+}
+
+func (c *Config) TxTTL() time.Duration {
+	return time.Duration(c.TxTTLMs) * time.Millisecond
+}
+
+// paramsFlag 可重复的 -policy-param key=value 参数
+type paramsFlag PolicyParams
+
+func (p paramsFlag) String() string {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%g", k, p[k]))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (p paramsFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("policy-param 格式应为 key=value: %q", s)
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fmt.Errorf("policy-param %s: %v", k, err)
+	}
+	p[k] = f
+	return nil
+}
+
+// shardMinersFlag 可重复的 -shard-miner id=name 参数，参数需在配置文件的 shardMiners 中给出
+type shardMinersFlag map[int]MinerConfig
+
+func (f shardMinersFlag) String() string {
+	ids := make([]int, 0, len(f))
+	for id := range f {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, fmt.Sprintf("%d=%s", id, f[id].Strategy))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f shardMinersFlag) Set(s string) error {
+	k, name, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("shard-miner 格式应为 id=name: %q", s)
+	}
+	id, err := strconv.Atoi(k)
+	if err != nil {
+		return fmt.Errorf("shard-miner 分片号 %q: %v", k, err)
+	}
+	mc := f[id]
+	if mc.Strategy != name {
+		mc = MinerConfig{Strategy: name}
+	}
+	f[id] = mc
+	return nil
+}
+
+// sinksFlag 可重复的 -sink type[=path] 参数，命令行给出时整体替换配置文件中的 sinks
+type sinksFlag struct {
+	sinks *[]SinkConfig
+	set   bool
+}
+
+func (f *sinksFlag) String() string {
+	if f.sinks == nil {
+		return ""
+	}
+	parts := make([]string, 0, len(*f.sinks))
+	for _, sc := range *f.sinks {
+		if sc.Path == "" {
+			parts = append(parts, sc.Type)
+		} else {
+			parts = append(parts, sc.Type+"="+sc.Path)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *sinksFlag) Set(s string) error {
+	if !f.set {
+		*f.sinks = nil
+		f.set = true
+	}
+	typ, path, _ := strings.Cut(s, "=")
+	*f.sinks = append(*f.sinks, SinkConfig{Type: typ, Path: path})
+	return nil
+}
+
+// bindFlags 把命令行参数绑定到 c 的各字段上，默认值取 c 当前值
+func (c *Config) bindFlags(fs *flag.FlagSet) {
+	fs.IntVar(&c.ShardNum, "shards", c.ShardNum, "分片数")
+	fs.StringVar(&c.TxsCsvPath, "csv", c.TxsCsvPath, "交易数据集 CSV 路径")
+	fs.IntVar(&c.DataTotalNum, "data-total", c.DataTotalNum, "顺序读取时最多读入的交易数")
+	fs.IntVar(&c.BlockSize, "block-size", c.BlockSize, "每个区块最多打包的交易数")
+	fs.Int64Var(&c.BlockGasLimit, "block-gas-limit", c.BlockGasLimit, "每个区块的 gas 上限，大于 0 时按 gas 打包、不限交易数")
+	fs.IntVar(&c.GlobalBatchSz, "batch-size", c.GlobalBatchSz, "从 CSV 一次拉的交易数")
+	fs.IntVar(&c.MaxBlocks, "max-blocks", c.MaxBlocks, "每个分片出块数上限")
+	fs.IntVar(&c.PoolCapacity, "pool-capacity", c.PoolCapacity, "每个分片交易池容量（不含 relay2），0 为不限")
+	fs.Int64Var(&c.TxTTLMs, "tx-ttl", c.TxTTLMs, "交易在池中的存活时间 (ms)，0 为不过期")
+	fs.Int64Var(&c.PriceBumpPercent, "price-bump", c.PriceBumpPercent, "替换池中同 nonce 交易所需的最低 GasPrice 涨幅 (%)")
+	fs.Int64Var(&c.Resubmit.StuckMs, "resubmit-stuck", c.Resubmit.StuckMs, "交易卡住多久 (ms) 后用户提价重发或取消，0 为关闭")
+	fs.Float64Var(&c.Resubmit.BumpProb, "resubmit-bump-prob", c.Resubmit.BumpProb, "卡住的交易每次出块后被提价重发的概率")
+	fs.Float64Var(&c.Resubmit.CancelProb, "resubmit-cancel-prob", c.Resubmit.CancelProb, "卡住的交易每次出块后被取消的概率")
+	fs.Int64Var(&c.Resubmit.BumpPercent, "resubmit-bump", c.Resubmit.BumpPercent, "用户提价重发、取消时 GasPrice 的涨幅 (%)")
+	fs.Int64Var(&c.AgingStep, "aging-step", c.AgingStep, "被税挤出的交易每多挨饿一个区块，排序用的收益提高多少 (wei)，0 为不启用")
+	fs.StringVar(&c.FeeModel, "fee-model", c.FeeModel, "费用模型: legacy eip1559")
+	fs.Int64Var(&c.FeeMarket.InitialBaseFee, "base-fee", c.FeeMarket.InitialBaseFee, "eip1559 各分片初始 base fee (wei/gas)")
+	fs.Int64Var(&c.FeeMarket.GasTarget, "gas-target", c.FeeMarket.GasTarget, "eip1559 每块目标 gas 用量，0 为 blockSize*21000/2")
+	fs.Int64Var(&c.FeeMarket.PriorityPercent, "priority-percent", c.FeeMarket.PriorityPercent, "eip1559 MaxPriorityFeePerGas 占 GasPrice 的百分比")
+	fs.StringVar(&c.Source.Type, "source", c.Source.Type, "交易注入方式: sequential loop prefixThenLoop concat")
+	fs.IntVar(&c.Source.Start, "source-start", c.Source.Start, "注入窗口起点（有效交易序号）")
+	fs.IntVar(&c.Source.End, "source-end", c.Source.End, "注入窗口终点（不含），0 表示 dataTotalNum")
+	fs.IntVar(&c.Source.LoopStart, "loop-start", c.Source.LoopStart, "prefixThenLoop 循环窗口起点")
+	fs.IntVar(&c.Source.LoopEnd, "loop-end", c.Source.LoopEnd, "prefixThenLoop 循环窗口终点（不含）")
+	fs.IntVar(&c.Source.Repeat, "loop-repeat", c.Source.Repeat, "loop 窗口循环次数，0 表示无限")
+	fs.StringVar(&c.CrossShard, "cross-shard", c.CrossShard, "跨分片机制: relay broker")
+	fs.StringVar(&c.BrokerFile, "broker-file", c.BrokerFile, "broker 地址文件")
+	fs.IntVar(&c.BrokerNum, "broker-num", c.BrokerNum, "取 broker 文件的前几个地址")
+	fs.Int64Var(&c.Delta, "delta", c.Delta, "tax & subsidy 调整步长")
+	fs.Int64Var(&c.EpsilonDelay, "eps-delay", c.EpsilonDelay, "时延平衡容忍区间")
+	fs.Int64Var(&c.EpsilonBalance, "eps-balance", c.EpsilonBalance, "税池平衡容忍区间")
+	fs.Int64Var(&c.EpsilonDeltaBalance, "eps-delta-balance", c.EpsilonDeltaBalance, "税池变化量容忍区间")
+	fs.StringVar(&c.Policy, "policy", c.Policy, "税池调节算法: "+strings.Join(TaxPolicyNames(), " "))
+	if c.PolicyParams == nil {
+		c.PolicyParams = PolicyParams{}
+	}
+	fs.Var(paramsFlag(c.PolicyParams), "policy-param", "税池调节算法参数 key=value，可重复")
+	fs.StringVar(&c.StepRule.Rule, "step-rule", c.StepRule.Rule, "v3.x 步长自适应规则: fixed aimd rprop momentum")
+	fs.Float64Var(&c.StepRule.Increase, "step-increase", c.StepRule.Increase, "aimd 同向调整时步长倍率的加量")
+	fs.Float64Var(&c.StepRule.Grow, "step-grow", c.StepRule.Grow, "rprop 同向调整时步长倍率的乘数")
+	fs.Float64Var(&c.StepRule.Shrink, "step-shrink", c.StepRule.Shrink, "aimd、rprop 调整方向变号时步长倍率的乘数")
+	fs.Float64Var(&c.StepRule.Beta, "step-beta", c.StepRule.Beta, "momentum 的衰减系数")
+	fs.Float64Var(&c.StepRule.MinMult, "step-min-mult", c.StepRule.MinMult, "步长倍率下限")
+	fs.Float64Var(&c.StepRule.MaxMult, "step-max-mult", c.StepRule.MaxMult, "步长倍率上限")
+	fs.StringVar(&c.Signal.Estimator, "signal", c.Signal.Estimator, "手续费信号估计方式: min quantile trimmed")
+	fs.Float64Var(&c.Signal.Quantile, "signal-quantile", c.Signal.Quantile, "quantile 估计的分位点")
+	fs.Float64Var(&c.Signal.Trim, "signal-trim", c.Signal.Trim, "trimmed 估计两端各去掉的比例")
+	fs.Float64Var(&c.Signal.Alpha, "signal-alpha", c.Signal.Alpha, "手续费信号跨区块 EWMA 中本块的权重，0 为不平滑")
+	fs.BoolVar(&c.Solvency.Floor, "balance-floor", c.Solvency.Floor, "开启税池余额下限，余额不足时补贴按比例配给")
+	fs.Int64Var(&c.Solvency.MinBalance, "min-balance", c.Solvency.MinBalance, "税池余额下限 (wei)，可为负")
+	fs.Float64Var(&c.Solvency.TaxCap, "tax-cap", c.Solvency.TaxCap, "每笔 ITX 的税不超过其手续费的比例，0 为不封顶")
+	fs.BoolVar(&c.Solvency.NonNegative, "non-negative", c.Solvency.NonNegative, "把调节算法给出的负 Tax、负 Subsidy 截为 0")
+	fs.BoolVar(&c.SolverBenchmark, "solver-benchmark", c.SolverBenchmark, "每块另求解出清的 Tax/Subsidy 作对照")
+	fs.StringVar(&c.Miner.Strategy, "miner", c.Miner.Strategy, "矿工打包策略: "+strings.Join(MinerStrategyNames(), " "))
+	if c.Miner.Params == nil {
+		c.Miner.Params = PolicyParams{}
+	}
+	fs.Var(paramsFlag(c.Miner.Params), "miner-param", "矿工策略参数 key=value，可重复")
+	if c.ShardMiners == nil {
+		c.ShardMiners = map[int]MinerConfig{}
+	}
+	fs.Var(shardMinersFlag(c.ShardMiners), "shard-miner", "单独指定某分片的矿工策略 id=name，可重复")
+	fs.Int64Var(&c.BlockIntervalMs, "block-interval", c.BlockIntervalMs, "出块间隔 (ms)")
+	fs.Int64Var(&c.InjectIntervalMs, "inject-interval", c.InjectIntervalMs, "交易注入间隔 (ms)")
+	fs.Int64Var(&c.RelayDelayMs, "relay-delay", c.RelayDelayMs, "relay2 交易送达时延 (ms)")
+	fs.Int64Var(&c.Seed, "seed", c.Seed, "随机数种子")
+	fs.StringVar(&c.OutputDir, "out", c.OutputDir, "输出目录")
+	fs.StringVar(&c.LogPath, "log", c.LogPath, "日志文件路径")
+	fs.BoolVar(&c.TxDetails, "tx-details", c.TxDetails, "逐笔写出交易明细 Tx_Details.csv")
+	fs.IntVar(&c.Checkpoint.Every, "checkpoint-every", c.Checkpoint.Every, "每出多少轮区块保存一次 checkpoint，运行结束时也保存，0 为不保存")
+	fs.StringVar(&c.Checkpoint.Path, "checkpoint-path", c.Checkpoint.Path, "checkpoint 保存路径，可用 {round}，默认 {outputDir}/checkpoint.gob")
+	fs.Var(&sinksFlag{sinks: &c.Sinks}, "sink", "出块统计输出 type[=path]，type 为 csv jsonl memory，可重复；path 中可用 {shard} {time}")
+}
+
+// LoadConfig 解析命令行：先读 -config 指定的 JSON 文件，再用命令行中显式给出的参数覆盖
+func LoadConfig(args []string) (*Config, error) {
+	// 第一遍只为拿到 -config
+	var configPath string
+	probe := flag.NewFlagSet("taxsim", flag.ContinueOnError)
+	probe.StringVar(&configPath, "config", "", "JSON 配置文件路径")
+	DefaultConfig().bindFlags(probe)
+	if err := probe.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := DefaultConfig()
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("读取配置文件失败: %v", err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("解析配置文件 %s 失败: %v", configPath, err)
+		}
+	}
+
+	// 第二遍把命令行参数覆盖到配置文件的结果上
+	fs := flag.NewFlagSet("taxsim", flag.ContinueOnError)
+	fs.String("config", "", "JSON 配置文件路径")
+	cfg.bindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// isLFSPointer path 是否为未取回的 Git LFS 指针文件
+func isLFSPointer(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, len(lfsPointerPrefix))
+	n, _ := io.ReadFull(f, head)
+	return string(head[:n]) == lfsPointerPrefix
+}
+
+// lfsPointerPrefix Git LFS 指针文件的第一行
+const lfsPointerPrefix = "version https://git-lfs.github.com/spec/"
+
+// Validate 检查配置取值是否合法
+func (c *Config) Validate() error {
+	var errs []error
+	if c.ShardNum <= 0 {
+		errs = append(errs, fmt.Errorf("shardNum 必须为正数: %d", c.ShardNum))
+	}
+	if c.TxsCsvPath == "" {
+		errs = append(errs, errors.New("txsCsvPath 未指定：用 -csv 或配置文件的 txsCsvPath 指定交易数据集（获取方式见 README）"))
+	} else if _, err := os.Stat(c.TxsCsvPath); err != nil {
+		errs = append(errs, fmt.Errorf("txsCsvPath 不可用: %v（数据集的获取见 README）", err))
+	} else if isLFSPointer(c.TxsCsvPath) {
+		errs = append(errs, fmt.Errorf("txsCsvPath %s 是 Git LFS 指针文件而不是数据，需先 git lfs pull 取回真实数据（见 README）", c.TxsCsvPath))
+	}
+	if c.DataTotalNum <= 0 {
+		errs = append(errs, fmt.Errorf("dataTotalNum 必须为正数: %d", c.DataTotalNum))
+	}
+	if c.BlockSize <= 0 {
+		errs = append(errs, fmt.Errorf("blockSize 必须为正数: %d", c.BlockSize))
+	}
+	if c.BlockGasLimit < 0 {
+		errs = append(errs, fmt.Errorf("blockGasLimit 不能为负: %d", c.BlockGasLimit))
+	}
+	if c.GlobalBatchSz <= 0 {
+		errs = append(errs, fmt.Errorf("globalBatchSz 必须为正数: %d", c.GlobalBatchSz))
+	}
+	if c.MaxBlocks <= 0 {
+		errs = append(errs, fmt.Errorf("maxBlocks 必须为正数: %d", c.MaxBlocks))
+	}
+	if c.PoolCapacity < 0 || c.TxTTLMs < 0 {
+		errs = append(errs, errors.New("poolCapacity 和 txTTLMs 不能为负"))
+	}
+	if c.AgingStep < 0 {
+		errs = append(errs, fmt.Errorf("agingStep 不能为负: %d", c.AgingStep))
+	}
+	if c.PriceBumpPercent < 0 {
+		errs = append(errs, fmt.Errorf("priceBumpPercent 不能为负: %d", c.PriceBumpPercent))
+	}
+	if err := c.Resubmit.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	switch c.FeeModel {
+	case FeeModelLegacy:
+	case FeeModelEIP1559:
+		if err := c.FeeMarket.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	default:
+		errs = append(errs, fmt.Errorf("未知的 feeModel: %q，可用: legacy, eip1559", c.FeeModel))
+	}
+	if err := c.Source.Validate(c); err != nil {
+		errs = append(errs, err)
+	}
+	switch c.CrossShard {
+	case CrossShardRelay:
+	case CrossShardBroker:
+		if _, err := os.Stat(c.BrokerFile); err != nil {
+			errs = append(errs, fmt.Errorf("brokerFile 不可用: %v", err))
+		}
+		if c.BrokerNum <= 0 {
+			errs = append(errs, fmt.Errorf("brokerNum 必须为正数: %d", c.BrokerNum))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("未知的 crossShard: %q，可用: relay, broker", c.CrossShard))
+	}
+	if c.Delta < 0 || c.EpsilonDelay < 0 || c.EpsilonBalance < 0 || c.EpsilonDeltaBalance < 0 {
+		errs = append(errs, errors.New("delta 和各 epsilon 不能为负"))
+	}
+	if _, err := NewTaxPolicy(c.Policy, c.PolicyParams, c); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.StepRule.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Signal.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Solvency.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	for name, sc := range c.PolicySignals {
+		if _, ok := taxPolicies[name]; !ok {
+			errs = append(errs, fmt.Errorf("policySignals 未知的 policy: %q", name))
+		}
+		if err := sc.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("policySignals %s: %v", name, err))
+		}
+	}
+	if _, err := NewMinerStrategy(c.Miner.Strategy, c.Miner.Params, c, 0); err != nil {
+		errs = append(errs, err)
+	}
+	for id, mc := range c.ShardMiners {
+		if id < 0 || id >= c.ShardNum {
+			errs = append(errs, fmt.Errorf("shardMiners 分片号超出范围 [0, %d): %d", c.ShardNum, id))
+			continue
+		}
+		if _, err := NewMinerStrategy(mc.Strategy, mc.Params, c, uint64(id)); err != nil {
+			errs = append(errs, fmt.Errorf("shard %d: %v", id, err))
+		}
+	}
+	if c.BlockIntervalMs <= 0 || c.InjectIntervalMs <= 0 {
+		errs = append(errs, errors.New("blockIntervalMs 和 injectIntervalMs 必须为正数"))
+	}
+	if c.RelayDelayMs < 0 {
+		errs = append(errs, fmt.Errorf("relayDelayMs 不能为负: %d", c.RelayDelayMs))
+	}
+	for _, sc := range c.Sinks {
+		if err := sc.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.Checkpoint.Every < 0 {
+		errs = append(errs, fmt.Errorf("checkpoint every 不能为负: %d", c.Checkpoint.Every))
+	}
+	if c.OutputDir == "" || c.LogPath == "" {
+		errs = append(errs, errors.New("outputDir 和 logPath 不能为空"))
+	}
+	return errors.Join(errs...)
+}
+
+// String 以 JSON 形式输出最终生效的配置
+func (c *Config) String() string {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%+v", *c)
+	}
+	return string(data)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// 交易费用模型
+const (
+	FeeModelLegacy  = "legacy"  // 一口价：矿工得到 GasPrice*GasUsed
+	FeeModelEIP1559 = "eip1559" // 各分片独立的 base fee 被销毁，矿工只得到小费
+)
+
+// FeeMarketConfig EIP-1559 模型参数。数据集只有 GasPrice，注入时取 MaxFeePerGas = GasPrice，
+// MaxPriorityFeePerGas = GasPrice * PriorityPercent / 100（100 即 legacy 交易在 EIP-1559 下的含义）
+type FeeMarketConfig struct {
+	InitialBaseFee    int64 `json:"initialBaseFee"`    // 各分片第一个区块的 base fee (wei/gas)
+	GasTarget         int64 `json:"gasTarget"`         // 每个区块的目标 gas 用量，0 为 blockGasLimit/2（未设 gas 上限时为 blockSize*21000/2）
+	ChangeDenominator int64 `json:"changeDenominator"` // 每块 base fee 最大变化为 1/ChangeDenominator
+	PriorityPercent   int64 `json:"priorityPercent"`
+}
+
+func DefaultFeeMarketConfig() FeeMarketConfig {
+	return FeeMarketConfig{
+		InitialBaseFee:    1000000000, // 1 gwei
+		ChangeDenominator: 8,
+		PriorityPercent:   100,
+	}
+}
+
+// Target 每个区块的目标 gas 用量
+func (fc FeeMarketConfig) Target(cfg *Config) int64 {
+	if fc.GasTarget > 0 {
+		return fc.GasTarget
+	}
+	if cfg.BlockGasLimit > 0 {
+		return cfg.BlockGasLimit / 2
+	}
+	return int64(cfg.BlockSize) * 21000 / 2
+}
+
+func (fc FeeMarketConfig) Validate() error {
+	var errs []error
+	if fc.InitialBaseFee < 0 || fc.GasTarget < 0 {
+		errs = append(errs, errors.New("feeMarket initialBaseFee 和 gasTarget 不能为负"))
+	}
+	if fc.ChangeDenominator <= 0 {
+		errs = append(errs, fmt.Errorf("feeMarket changeDenominator 必须为正数: %d", fc.ChangeDenominator))
+	}
+	if fc.PriorityPercent < 0 || fc.PriorityPercent > 100 {
+		errs = append(errs, fmt.Errorf("feeMarket priorityPercent 须在 [0, 100] 内: %d", fc.PriorityPercent))
+	}
+	return errors.Join(errs...)
+}
+
+// applyFeeMarket 按 EIP-1559 模型给注入的交易设置出价上限
+func applyFeeMarket(tx *Transaction, fc FeeMarketConfig) {
+	tx.MaxFeePerGas = new(big.Int).Set(tx.GasPrice)
+	tip := new(big.Int).Mul(tx.GasPrice, big.NewInt(fc.PriorityPercent))
+	tx.MaxPriorityFeePerGas = tip.Div(tip, big.NewInt(100))
+}
+
+// effectiveTip 在 baseFee 下矿工得到的每 gas 小费 min(MaxPriorityFeePerGas, MaxFeePerGas-baseFee)，
+// 为负表示出价低于 base fee、不能上链
+func effectiveTip(tx *Transaction, baseFee *big.Int) *big.Int {
+	tip := new(big.Int).Sub(tx.MaxFeePerGas, baseFee)
+	if tip.Cmp(tx.MaxPriorityFeePerGas) > 0 {
+		tip.Set(tx.MaxPriorityFeePerGas)
+	}
+	return tip
+}
+
+// legGas 交易在本分片计的 gas：跨分片交易两段各计一半，与手续费在两个分片间平分一致
+func legGas(tx *Transaction) *big.Int {
+	gas := new(big.Int).Set(tx.GasUsed)
+	if tx.isCTX {
+		gas.Div(gas, big.NewInt(2))
+	}
+	return gas
+}
+
+// minerFee 矿工从整笔交易得到的手续费（不含税/补贴）：legacy 为 GasPrice*GasUsed，EIP-1559 为 EffectiveTip*GasUsed
+func minerFee(tx *Transaction) *big.Int {
+	if tx.EffectiveTip != nil {
+		return new(big.Int).Mul(tx.EffectiveTip, tx.GasUsed)
+	}
+	return new(big.Int).Mul(tx.GasPrice, tx.GasUsed)
+}
+
+// nextBaseFee 按 EIP-1559 由本块 gas 用量相对目标的偏离调整下一块的 base fee，上调时至少加 1
+func nextBaseFee(baseFee *big.Int, gasUsed, target, denominator int64) *big.Int {
+	if gasUsed == target {
+		return new(big.Int).Set(baseFee)
+	}
+	delta := new(big.Int).Mul(baseFee, big.NewInt(gasUsed-target))
+	delta.Quo(delta, big.NewInt(target))
+	delta.Quo(delta, big.NewInt(denominator))
+	if gasUsed > target && delta.Sign() == 0 {
+		delta.SetInt64(1)
+	}
+	next := delta.Add(delta, baseFee)
+	if next.Sign() < 0 {
+		next.SetInt64(0)
+	}
+	return next
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// LatencyStats 一组交易确认时延的分布，Count 为 0 时其余字段无意义
+type LatencyStats struct {
+	Count int           `json:"count"`
+	Mean  time.Duration `json:"mean"`
+	P50   time.Duration `json:"p50"`
+	P95   time.Duration `json:"p95"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+// NewLatencyStats 统计 samples 的分布，分位数取 nearest-rank，会对 samples 原地排序
+func NewLatencyStats(samples []time.Duration) LatencyStats {
+	n := len(samples)
+	if n == 0 {
+		return LatencyStats{}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	sum := time.Duration(0)
+	for _, d := range samples {
+		sum += d
+	}
+	rank := func(p int) time.Duration {
+		k := (p*n + 99) / 100 // ceil(p/100 * n)
+		if k < 1 {
+			k = 1
+		}
+		return samples[k-1]
+	}
+	return LatencyStats{
+		Count: n,
+		Mean:  sum / time.Duration(n),
+		P50:   rank(50),
+		P95:   rank(95),
+		P99:   rank(99),
+		Max:   samples[n-1],
+	}
+}
+
+// latencyHeader 生成 prefix 开头的 mean/p50/p95/p99/max 列名（ms）
+func latencyHeader(prefix string) []string {
+	return []string{
+		prefix + " Latency Mean(ms)", prefix + " Latency P50(ms)", prefix + " Latency P95(ms)",
+		prefix + " Latency P99(ms)", prefix + " Latency Max(ms)",
+	}
+}
+
+// CSVCells 与 latencyHeader 对应，没有样本时写空串（pandas 读入为 NaN）
+func (ls LatencyStats) CSVCells() []string {
+	if ls.Count == 0 {
+		return []string{"", "", "", "", ""}
+	}
+	ms := func(d time.Duration) string { return fmt.Sprint(d.Milliseconds()) }
+	return []string{ms(ls.Mean), ms(ls.P50), ms(ls.P95), ms(ls.P99), ms(ls.Max)}
+}
@@ -1,478 +1,297 @@
 package main
 
 import (
-	"encoding/csv"
 	"fmt"
-	"io"
 	"log"
+	"math/rand"
 	"os"
+	"sort"
+	"sync"
 	"time"
 )
 
-const (
-	ShardNum   = 4
-	txsCsvPath = "./filtered_transactions_11000k.csv"
-	//dataTotalNum = 30207 // 100k txsCsv数据条数
-	dataTotalNum  = 3607054 // 1100k txsCsv数据条数
-	blockSize     = 2000
-	globalBatchSz = 10000 // 从 CSV 一次拉 10000 笔
-)
-
-var logChan = make(chan string, 100000000)
-
+// BlockStats 一个区块的出块统计，JSON 输出时时长字段单位为 ns
 type BlockStats struct {
-	BlockHeight   int
-	TxPoolSize    int
-	TxCount       int
-	Diff          string
-	Balance       string
-	DeltaBalance  string
-	Tax           string
-	Subsidy       string
-	F_itx_min     string
-	F_ctx_min     string
-	P_itx_min     string
-	P_ctx_min     string
-	StartTime     time.Time
-	EndTime       time.Time
-	BlockInterval time.Duration // 记录与上一个区块的时间差
+	BlockHeight   int             `json:"blockHeight"`
+	TxPoolSize    int             `json:"txPoolSize"`
+	TxCount       int             `json:"txCount"`
+	Diff          string          `json:"diff"`
+	Balance       string          `json:"balance"`
+	DeltaBalance  string          `json:"deltaBalance"`
+	Tax           string          `json:"tax"`
+	Subsidy       string          `json:"subsidy"`
+	F_itx_min     string          `json:"fItxMin"`
+	F_ctx_min     string          `json:"fCtxMin"`
+	P_itx_min     string          `json:"pItxMin"`
+	P_ctx_min     string          `json:"pCtxMin"`
+	StartTime     time.Time       `json:"startTime"`
+	EndTime       time.Time       `json:"endTime"`
+	BlockInterval time.Duration   `json:"blockInterval"` // 记录与上一个区块的时间差
+	ShardID       uint64          `json:"shardID"`       // 出块分片
+	Relay1Count   int             `json:"relay1Count"`   // 本块打包的 relay1 交易数（跨分片交易第一段，broker 模式下为 broker1）
+	Relay2Count   int             `json:"relay2Count"`   // 本块打包的 relay2 交易数（跨分片交易第二段，broker 模式下为 broker2）
+	RelayPoolSize int             `json:"relayPoolSize"` // 出块后本分片 RelayPool 中待打包的 relay2 交易数
+	AvgCTXLatency time.Duration   `json:"avgCTXLatency"` // 本块 relay2 交易的端到端确认时延均值（交易提出 -> relay2 上链）
+	ITXLatency    LatencyStats    `json:"itxLatency"`    // 本块片内交易的确认时延分布（交易提出 -> 上链）
+	CTXLatency    LatencyStats    `json:"ctxLatency"`    // 本块 relay2 交易的端到端确认时延分布
+	QueuedSize    int             `json:"queuedSize"`    // 出块后 TxPoolSize 中因 nonce 空缺暂不可打包的交易数
+	EvictedITX    int             `json:"evictedITX"`    // 上一块以来因交易池满被驱逐的片内交易数
+	EvictedCTX    int             `json:"evictedCTX"`    // 上一块以来因交易池满被驱逐的 relay1 交易数
+	ExpiredITX    int             `json:"expiredITX"`    // 上一块以来超过存活时间被丢弃的片内交易数
+	ExpiredCTX    int             `json:"expiredCTX"`    // 上一块以来超过存活时间被丢弃的 relay1 交易数
+	Replaced      int             `json:"replaced"`      // 上一块以来被同 nonce 提价交易替换的交易数
+	Cancelled     int             `json:"cancelled"`     // 上一块以来被零金额自转账取消的交易数
+	BaseFee       string          `json:"baseFee"`       // EIP-1559 模型下本块的 base fee，legacy 为空
+	GasUsed       int64           `json:"gasUsed"`       // 本块 gas 用量，跨分片交易每段计一半
+	Burned        string          `json:"burned"`        // 本块销毁的 base fee，legacy 为空
+	TotalBurned   string          `json:"totalBurned"`   // 本分片累计销毁的 base fee，legacy 为空
+	GasLimit      int64           `json:"gasLimit"`      // 区块 gas 上限，按交易数打包时为 0
+	GasUtil       float64         `json:"gasUtil"`       // GasUsed / GasLimit，按交易数打包时为 0
+	Starvation    StarvationStats `json:"starvation"`    // 出块后池中被税挤出的交易
+	Miner         string          `json:"miner"`         // 本分片矿工的打包策略
+	PID           PIDStats        `json:"pid"`           // pid 算法给出下一块 Tax/Subsidy 时各项的贡献
+	SolvedTax     string          `json:"solvedTax"`     // 按交易池内容求解的出清 Tax，与 Tax 并列作对照，未开启 solverBenchmark 时为空
+	SolvedSubsidy string          `json:"solvedSubsidy"` // 出清 Subsidy
+	Signal        SignalStats     `json:"signal"`        // 本块的手续费信号，平滑前后
+	Step          StepStats       `json:"step"`          // v3.x 本块实际使用的步长
+	Solvency      SolvencyStats   `json:"solvency"`      // 本块补贴应发与实发、itx 因封顶少收的税
 }
 
-var statsChan = make(chan BlockStats, 10000)
-var batchReq = make(chan struct{}, 1) // 打包协程按需请求
-
 func main() {
-	// 1) 启动日志输出协程
-	f, err := os.Create("exp.log")
-	if err != nil {
-		log.Fatal(err)
+	// taxsim sweep ...：参数扫描，并行跑多组配置
+	if len(os.Args) > 1 && os.Args[1] == "sweep" {
+		runSweep(os.Args[2:])
+		return
 	}
-	logger := log.New(f, "", log.Ldate|log.Ltime)
-	go func() {
-		for msg := range logChan {
-			logger.Println(msg)
-		}
-	}()
-	// 2) 启动 CSV 写入协程
-	go startCSVWriter()
-	//=========================================================================
-	// 2) 启动读 CSV 协程
-	done := make(chan bool)
-	csvTxPool := &TxPool{
-		TxQueue:   make([]*Transaction, 0),
-		RelayPool: make(map[uint64][]*Transaction),
+	// taxsim resume ...：从 checkpoint 继续；taxsim fork ...：从同一个 checkpoint 分叉出多个运行
+	if len(os.Args) > 1 && os.Args[1] == "resume" {
+		runResume(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "fork" {
+		runFork(os.Args[2:])
+		return
+	}
+	// taxsim bench ...：交易池打包性能对比
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		runBench(os.Args[2:])
+		return
 	}
 
-	go ReadTxsCSV_SegmentAndRepeat(csvTxPool, done)
-
-	// 3) 触发第一次批量读取
-	batchReq <- struct{}{}
-
-	// 4) 启动打包协程
-	GenerateBlock(csvTxPool, done)
-
-	// 停止读取协程
-	close(batchReq)
-
-	// 确保 logChan 输出完后退出
-	time.Sleep(3 * time.Second)
-	close(logChan)
-	close(statsChan)
-	f.Close()
-}
-
-// ReadTxsCSV 读入交易 csv，读完停机
-func ReadTxsCSV(txpool *TxPool, done chan<- bool) {
-	start := time.Now()
-	nowDataNum := 0
-
-	txfile, err := os.Open(txsCsvPath)
+	// 0) 解析配置文件和命令行参数
+	cfg, err := LoadConfig(os.Args[1:])
 	if err != nil {
-		log.Panic(err)
+		log.Fatalf("配置错误: %v", err)
 	}
-	defer txfile.Close()
-	reader := csv.NewReader(txfile)
-
-	// 设置定期打印的时间间隔
-	logInterval := 2 * time.Second
-	lastLogTime := time.Now()
-
-	for {
-		<-batchReq
-		txpool.lock.Lock()
-		for i := 0; i < globalBatchSz; i++ {
-			data, err := reader.Read()
-			if err == io.EOF || nowDataNum >= dataTotalNum {
-				txpool.lock.Unlock()
-				done <- true
-				return
-			}
-			if err != nil {
-				txpool.lock.Unlock()
-				log.Panic(err)
-			}
-			if tx, ok := data2tx(data, uint64(nowDataNum)); ok {
-				//tx.PrintTx()  //打印调试
-				txpool.TxQueue = append(txpool.TxQueue, tx)
-				nowDataNum++
-			}
+	fmt.Printf("运行配置:\n%s\n", cfg)
 
-			// 每隔 logInterval 秒打印一次状态
-			if time.Since(lastLogTime) >= logInterval {
-				elapsed := time.Since(start).Seconds()
-				progress := float64(nowDataNum) / float64(dataTotalNum) * 100
-				//logChan <- fmt.Sprintf("📊 正在读取交易：已读取 %d / %d (%.2f%%)，耗时 %.2f 秒",
-				//	nowDataNum, dataTotalNum, progress, elapsed)
-				fmt.Printf("📊 正在读取交易：已读取 %d / %d (%.2f%%)，耗时 %.2f 秒\n",
-					nowDataNum, dataTotalNum, progress, elapsed)
-				lastLogTime = time.Now()
-			}
-		}
-		txpool.lock.Unlock()
+	if err := NewSim(cfg).Run(); err != nil {
+		log.Fatal(err)
 	}
+}
 
-	duration := time.Since(start)
-	logChan <- fmt.Sprintf("ReadTxsCSV=> TxsCSV 读取完成，共 %d 笔交易，用时 %.2f 秒", nowDataNum, duration.Seconds())
-	done <- true // 通知主线程“读取完毕”
+// simRun GenerateBlock 一次运行的全部可变状态，checkpoint 即保存这些状态（见 checkpoint.go）
+type simRun struct {
+	sim         *Sim
+	cfg         *Config
+	sched       *Scheduler
+	rngSrc      *countingSource
+	rng         *rand.Rand // 交易到达时间和用户重发共用，保证可复现
+	src         Source
+	shards      []*Shard
+	srcFinished bool
+	batchCount  int
+	rounds      int              // 已执行的出块轮数
+	nextInject  time.Time        // 下一次注入的时刻，来源耗尽后为零值
+	nextBlock   time.Time        // 下一轮出块的时刻
+	relays      []*relayDelivery // 已发出、尚未送达目的分片的 relay2 交易，按发出顺序
+	nonces      map[nonceKey]uint64
 }
 
-var originalTxs []*Transaction // 存储原始10000笔交易
+// nonceKey 各账户在各分片的下一个 nonce，注入时按数据集中的顺序分配（数据集没有 nonce，loop 注入的副本也要重新分配）；
+// broker 在每个分片都有账户，所以按 (账户, 分片) 计
+type nonceKey struct {
+	Addr  Address
+	Shard int
+}
 
-// 只读取一次CSV，然后循环复用
-func ReadTxsCSV_repeat(txpool *TxPool, done chan<- bool) {
-	//start := time.Now()
-	nowDataNum := 0
-	maxRepeatNum := 10000 // 循环使用这10000笔
+// relayDelivery 一组在 At 时刻从分片 From 送达分片 To 的 relay2 交易
+type relayDelivery struct {
+	At       time.Time
+	From, To uint64
+	Txs      []*Transaction
+}
 
-	txfile, err := os.Open(txsCsvPath)
-	if err != nil {
-		log.Panic(err)
+// GenerateBlock 多分片出块，由离散事件调度器驱动：
+// 每隔 InjectInterval 从 src 注入一批交易并按 sender 所在分片分发，
+// 每隔 BlockInterval ShardNum 个分片并发各自打包一个区块，relay2 交易经 RelayDelay 后送达目的分片。
+// 所有时间戳取自模拟时钟，同输入同种子的两次运行结果一致。sim.resume 不为 nil 时从 checkpoint 的状态继续
+func (sim *Sim) GenerateBlock(src Source) error {
+	cfg := sim.cfg
+	r := &simRun{sim: sim, cfg: cfg, src: src, rngSrc: newCountingSource(cfg.Seed), nonces: make(map[nonceKey]uint64)}
+	r.rng = rand.New(r.rngSrc)
+	r.shards = make([]*Shard, cfg.ShardNum)
+	for i := range r.shards {
+		r.shards[i] = NewShard(uint64(i), cfg, sim.logChan)
 	}
-	defer txfile.Close()
-	reader := csv.NewReader(txfile)
 
-	// ========== 一次性读取 10000 笔 ==========
-	for i := 0; i < maxRepeatNum; i++ {
-		data, err := reader.Read()
-		if err == io.EOF {
-			break
+	if sim.resume != nil {
+		if err := r.restore(sim.resume); err != nil {
+			return fmt.Errorf("从 checkpoint 恢复失败: %v", err)
 		}
-		if err != nil {
-			log.Panic(err)
+		sim.logChan <- fmt.Sprintf("GenerateBlock=> 从 checkpoint 恢复：已出块 %d 轮，模拟时间 %s", r.rounds, r.sched.Now().Sub(SimEpoch))
+		for _, d := range r.relays {
+			r.scheduleRelay(d)
 		}
-		if tx, ok := data2tx(data, uint64(nowDataNum)); ok {
-			originalTxs = append(originalTxs, tx)
-			nowDataNum++
+		if !r.nextInject.IsZero() {
+			r.sched.Schedule(r.nextInject, PrioTxArrival, r.injectTxs)
 		}
+	} else {
+		r.sched = NewScheduler(SimEpoch)
+		r.nextInject = SimEpoch.Add(cfg.InjectInterval())
+		r.nextBlock = SimEpoch.Add(cfg.BlockInterval())
+		r.sched.Schedule(r.nextInject, PrioTxArrival, r.injectTxs)
 	}
-	fmt.Println("✅ ReadTxsCSV => 首次读取 %d 笔交易成功，开始循环复用...", nowDataNum)
-
-	// ========== 循环监听 batchReq，复制复用 ==========
-	for {
-		_, ok := <-batchReq
-		if !ok {
-			break
-		}
-		txpool.lock.Lock()
-		for _, tx := range originalTxs {
-			cloned := *tx            // 浅拷贝
-			cloned.Time = time.Now() // 时间更新
-			txpool.TxQueue = append(txpool.TxQueue, &cloned)
-		}
-		txpool.lock.Unlock()
-	}
-	fmt.Println("ReadTxsCSV => 停止复用交易")
-	done <- true
+	r.sched.Schedule(r.nextBlock, PrioBlock, r.produceBlocks)
+	r.sched.Run()
+	return nil
 }
 
-func ReadTxsCSV_repeat10w211w(txpool *TxPool, done chan<- bool) {
-	start := time.Now()
-	nowDataNum := 0
-	startRepeatIdx := 100000
-	endRepeatIdx := 110000
-
-	txfile, err := os.Open(txsCsvPath)
-	if err != nil {
-		log.Panic(err)
+// injectTxs 交易到达：取一批交易，批内交易到达时间随机分布在 (now-InjectInterval, now]
+func (r *simRun) injectTxs() {
+	cfg, logChan := r.cfg, r.sim.logChan
+	batch, ok := r.src.NextBatch()
+	if !ok {
+		r.srcFinished = true
+		r.nextInject = time.Time{}
+		logChan <- fmt.Sprintf("GenerateBlock=> 交易来源已耗尽，共注入 %d 批", r.batchCount)
+		return
 	}
-	defer txfile.Close()
-	reader := csv.NewReader(txfile)
-
-	// 预分配空间
-	initialTxs := make([]*Transaction, 0, startRepeatIdx)
-	repeatTxs := make([]*Transaction, 0, endRepeatIdx-startRepeatIdx)
+	r.batchCount++
+	logChan <- fmt.Sprintf("GenerateBlock=> 第 %d 次注入：%d 笔交易", r.batchCount, len(batch))
 
-	// 一次性读入 0 - 11w
-	for nowDataNum < endRepeatIdx {
-		data, err := reader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Panic(err)
-		}
-		if tx, ok := data2tx(data, uint64(nowDataNum)); ok {
-			if nowDataNum < startRepeatIdx {
-				initialTxs = append(initialTxs, tx)
-			} else {
-				repeatTxs = append(repeatTxs, tx)
-			}
-			nowDataNum++
-		}
-	}
-
-	// 注入 0 ~ 10w（仅一次）
-	txpool.lock.Lock()
-	for _, tx := range initialTxs {
-		txpool.TxQueue = append(txpool.TxQueue, tx)
+	offsets := make([]int64, len(batch))
+	for i := range offsets {
+		offsets[i] = r.rng.Int63n(int64(cfg.InjectInterval())) + 1
 	}
-	txpool.lock.Unlock()
-	fmt.Printf("ReadTxsCSV => 已注入前 %d 笔交易\n", len(initialTxs))
-
-	// 等待 batchReq，循环注入 10w ~ 11w
-	fmt.Printf("ReadTxsCSV => 开始循环注入 %d 笔交易\n", len(repeatTxs))
-	for {
-		_, ok := <-batchReq
-		if !ok {
-			break
-		}
-		txpool.lock.Lock()
-		for _, tx := range repeatTxs {
-			cloned := *tx
-			cloned.Time = time.Now()
-			txpool.TxQueue = append(txpool.TxQueue, &cloned)
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	windowStart := r.sched.Now().Add(-cfg.InjectInterval())
+
+	// 逐笔分发：每笔交易进入 sender 所在分片的交易池。nonce 与交易池的状态无关，
+	// 交易被驱逐或过期后该账户之后的交易留在 queued 中等待空缺
+	for i, tx := range batch {
+		tx.Time = windowStart.Add(time.Duration(offsets[i]))
+		isCTX, sid, broker := routeTx(tx, cfg.ShardNum, r.sim.brokers)
+		tx.isCTX, tx.Broker = isCTX, broker
+		if cfg.FeeModel == FeeModelEIP1559 {
+			applyFeeMarket(tx, cfg.FeeMarket)
 		}
-		txpool.lock.Unlock()
+		key := nonceKey{tx.Sender, sid}
+		tx.Nonce = r.nonces[key]
+		r.nonces[key]++
+		r.shards[sid].TxPool.AddTx2Pool(tx)
 	}
-	fmt.Println("ReadTxsCSV => 停止注入交易")
 
-	end := time.Now()
-	duration := end.Sub(start)
-	fmt.Printf("ReadTxsCSV => 总耗时 %.2f 秒，终止时间：%s\n", duration.Seconds(), end.Format("2006-01-02 15:04:05"))
-
-	done <- true
+	r.nextInject = r.sched.Now().Add(cfg.InjectInterval())
+	r.sched.Schedule(r.nextInject, PrioTxArrival, r.injectTxs)
 }
 
-func ReadTxsCSV_SegmentAndRepeat(txpool *TxPool, done chan<- bool) {
-	start := time.Now()
-	txfile, err := os.Open(txsCsvPath)
-	if err != nil {
-		log.Panic(err)
-	}
-	defer txfile.Close()
-	reader := csv.NewReader(txfile)
-
-	totalNeeded := 1100000 // 读取 0~11w
-	allTxs := make([]*Transaction, 0, totalNeeded)
-
-	// ===== 1. 一次性读入前 11w 交易 =====
-	idx := 0
-	for idx < totalNeeded {
-		data, err := reader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Panic(err)
-		}
-		if tx, ok := data2tx(data, uint64(idx)); ok {
-			allTxs = append(allTxs, tx)
-			idx++
+// produceBlocks 出块：各分片并发出块，已达 MaxBlocks 个区块的分片不再出块
+func (r *simRun) produceBlocks() {
+	cfg, shards := r.cfg, r.shards
+	now := r.sched.Now()
+	r.rounds++
+	results := make([]BlockStats, cfg.ShardNum)
+	produced := make([]bool, cfg.ShardNum)
+	var wg sync.WaitGroup
+	for i, s := range shards {
+		if s.BlockNum() >= cfg.MaxBlocks {
+			continue
 		}
+		wg.Add(1)
+		go func(i int, s *Shard) {
+			defer wg.Done()
+			results[i], produced[i] = s.ProduceBlock(now)
+		}(i, s)
 	}
-	fmt.Printf("✅ 已读取 %d 笔交易（0~11w），开始按需注入...\n", len(allTxs))
-
-	// ✅ 正确设置循环使用的交易子集（10w ~ 11w）
-	repeatTxs := allTxs[1000000:1010000]
+	wg.Wait()
 
-	// ===== 2. 请求控制注入 =====
-	batchCount := 0
-
-	for {
-		_, ok := <-batchReq
-		if !ok {
-			break
-		}
-
-		if batchCount < 100 {
-			// 注入前10w
-			startIdx := batchCount * 10000
-			endIdx := (batchCount + 1) * 10000
-			if endIdx > 1000000 {
-				endIdx = 1000000
-			}
-			txpool.lock.Lock()
-			for _, tx := range allTxs[startIdx:endIdx] {
-				txpool.TxQueue = append(txpool.TxQueue, tx)
-			}
-			txpool.lock.Unlock()
-			fmt.Printf("📦 第 %d 次注入：%d ~ %d\n", batchCount+1, startIdx, endIdx-1)
-		} else {
-			// 之后每次循环注入10w~11w
-			txpool.lock.Lock()
-			for _, tx := range repeatTxs {
-				cloned := *tx
-				cloned.Time = time.Now()
-				txpool.TxQueue = append(txpool.TxQueue, &cloned)
+	for i, s := range shards {
+		if produced[i] {
+			r.sim.statsChan <- results[i]
+			if details := s.TakeTxDetails(); len(details) > 0 {
+				r.sim.txDetails <- details
 			}
-			txpool.lock.Unlock()
-			fmt.Printf("🔁 循环注入第 %d 次 10w~11w 交易（共 %d）\n", batchCount-9, len(repeatTxs))
 		}
-		batchCount++
 	}
 
-	fmt.Println("🚪 读取线程结束，已完成全部注入")
-	done <- true
-
-	duration := time.Since(start)
-	fmt.Printf("ReadTxsCSV => 总耗时 %.2f 秒，终止时间：%s\n", duration.Seconds(), time.Now().Format("2006-01-02 15:04:05"))
-}
-
-// GenerateBlock_version_timeSleep 负责打包交易并输出记录,用 time sleep控制出块间隔版本
-func GenerateBlock(csvPool *TxPool, done <-chan bool) {
-	shardQ := NewTxPool()
-	prevEnd := time.Time{}
-
-	taxpool := NewTaxPool()
-	blockNum := 1
-	csvFinished := false
-
-	for !csvFinished {
-		// 如果 shardQ 不足 blockSize，先从 csvPool 拉入满足 shard 的
-		//if len(shardQ.TxQueue) < blockSize {
-		if true {
-			// 批量过滤
-			filtered := make([]*Transaction, 0)
-			csvPool.lock.Lock()
-			for _, tx := range csvPool.TxQueue {
-				if Addr2Shard(tx.Sender) == 0 {
-					filtered = append(filtered, tx)
-				}
-			}
-			csvPool.TxQueue = csvPool.TxQueue[:0] // 清空 CSV 池
-			csvPool.lock.Unlock()
-			shardQ.AddTxs2Pool(filtered)
-
-			// 发请求再拉下一批
-			select {
-			case batchReq <- struct{}{}:
-			default:
-			}
-		}
-
-		// 如果池中没一笔，且 CSV 完了，就退出
-		if len(shardQ.TxQueue) == 0 && csvFinished {
-			return
-		}
-
-		// —— 等待 CSV 完毕标识
-		select {
-		case <-done:
-			csvFinished = true
-		default:
+	// 本轮产生的 relay2 交易经 relayDelay 投递到目的分片的 RelayPool
+	for _, s := range shards {
+		relayByShard := make([][]*Transaction, cfg.ShardNum)
+		for _, tx := range s.TakeRelayTxs() {
+			rsid := Addr2Shard(tx.Recipient, cfg.ShardNum)
+			relayByShard[rsid] = append(relayByShard[rsid], tx)
 		}
-
-		if shardQ.GetTxQueueLen() == 0 {
-			time.Sleep(10 * time.Millisecond)
-			continue
-		}
-
-		logChan <- fmt.Sprintf("GenerateBlock=>  Block %d - 当前交易池大小：%d\n", blockNum, shardQ.GetTxQueueLen())
-
-		// 记录打包时间
-		start := time.Now()
-
-		// 每次打包最多 blockSize 个交易
-		txs := shardQ.PackTxs(blockSize, taxpool)
-		// 给每个交易赋值shardID & BlockNumber，这里假装是4分片中的0分片
-		for _, tx := range txs {
-			tx.ShardID = 0 // 可视情况分配
-			tx.BlockNumber = uint64(blockNum)
-		}
-
-		// 更新 taxpool
-		//taxpool.UpdateDiffAndBalance(txs) //看 f_itx_min和f_ctx_min是否符合预期调试
-		taxpool.UpdateTaxAndSubsidy_v3_4(txs)
-
-		end := time.Now()
-		interval := time.Duration(0)
-		if !prevEnd.IsZero() {
-			interval = start.Sub(prevEnd)
+		for rsid, txs := range relayByShard {
+			if len(txs) == 0 {
+				continue
+			}
+			d := &relayDelivery{At: now.Add(cfg.RelayDelay()), From: s.ID, To: uint64(rsid), Txs: txs}
+			r.relays = append(r.relays, d)
+			r.scheduleRelay(d)
 		}
-		prevEnd = end
+	}
 
-		statsChan <- BlockStats{
-			BlockHeight:   blockNum,
-			TxPoolSize:    len(shardQ.TxQueue),
-			TxCount:       len(txs),
-			Diff:          taxpool.Diff_withsign.String(),
-			Balance:       taxpool.Balance.String(),
-			DeltaBalance:  taxpool.DeltaBalance.String(),
-			Tax:           taxpool.Tax.String(),
-			Subsidy:       taxpool.Subsidy.String(),
-			F_itx_min:     safeStr(taxpool.F_itx_min),
-			F_ctx_min:     safeStr(taxpool.F_ctx_min),
-			P_itx_min:     safeStr(taxpool.P_itx_min),
-			P_ctx_min:     safeStr(taxpool.P_ctx_min),
-			StartTime:     start,
-			EndTime:       end,
-			BlockInterval: interval,
+	// 池中卡住的交易的发送方看到本轮出块结果后提价重发或取消
+	if cfg.Resubmit.Enabled() {
+		for _, s := range shards {
+			resubmitStuck(s.TxPool, cfg.Resubmit, now, r.rng)
 		}
+	}
 
-		//fmt.Printf("完成区块 %d 打包：共 %d 笔交易\n", blockNum, len(txs))
-		logChan <- fmt.Sprintf("GenerateBlock=> 完成区块 %d 打包：共 %d 笔交易", blockNum, len(txs))
-
-		blockNum++
-		//batchReq <- struct{}{}
-
-		if blockNum > 600 {
-			fmt.Println("达到 600 个区块，终止出块")
+	finished := true
+	for _, s := range shards {
+		if s.BlockNum() < cfg.MaxBlocks {
+			finished = false
 			break
 		}
 	}
-}
-
-func startCSVWriter() {
-	outputDir := "outputCSV"
-	timestamp := time.Now().Format("20060102_150405")
-	filename := fmt.Sprintf("%s/shard_%s.csv", outputDir, timestamp)
-	err := os.MkdirAll(outputDir, os.ModePerm)
-	if err != nil {
-		log.Fatalf("创建目录失败: %v", err)
+	// 所有分片池中都没交易，且交易来源耗尽了，就退出
+	pending := 0
+	for _, d := range r.relays {
+		pending += len(d.Txs)
 	}
-	file, err := os.Create(filename)
-	if err != nil {
-		log.Fatalf("无法创建 CSV 文件: %v", err)
+	for _, s := range shards {
+		pending += s.TxPool.GetTxQueueLen() + s.TxPool.GetRelayPoolLen()
 	}
-	defer file.Close()
+	stop := finished || (pending == 0 && r.srcFinished)
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	// 停止时也记下一轮的出块时刻，从最后的 checkpoint 恢复时可以调大 maxBlocks 继续出块
+	r.nextBlock = now.Add(cfg.BlockInterval())
+	if !stop {
+		r.sched.Schedule(r.nextBlock, PrioBlock, r.produceBlocks)
+	}
+	if cp := cfg.Checkpoint; cp.Every > 0 && (stop || r.rounds%cp.Every == 0) {
+		r.saveCheckpoint()
+	}
 
-	header := []string{
-		"Block Height", "TxPool Size", "# of all Txs",
-		"Diff", "Balance", "DeltaBalance", "Tax", "Subsidy", "f_itx_min", "f_ctx_min",
-		"P_itx_min", "P_ctx_min", "StartTime", "EndTime", "BlockInterval(ms)",
+	if finished {
+		r.sim.logChan <- fmt.Sprintf("GenerateBlock=> 所有分片达到 %d 个区块，终止出块", cfg.MaxBlocks)
+	}
+	if stop {
+		r.sched.Stop()
 	}
-	writer.Write(header)
+}
 
-	for stat := range statsChan {
-		row := []string{
-			fmt.Sprint(stat.BlockHeight),
-			fmt.Sprint(stat.TxPoolSize),
-			fmt.Sprint(stat.TxCount),
-			//fmt.Sprint(stat.Relay1Count),
-			//fmt.Sprint(stat.Relay2Count),
-			stat.Diff,
-			stat.Balance,
-			stat.DeltaBalance,
-			stat.Tax,
-			stat.Subsidy,
-			stat.F_itx_min,
-			stat.F_ctx_min,
-			stat.P_itx_min,
-			stat.P_ctx_min,
+// scheduleRelay 在 d.At 时刻把 d 中的 relay2 交易投递到目的分片
+func (r *simRun) scheduleRelay(d *relayDelivery) {
+	r.sched.Schedule(d.At, PrioRelayDelivery, func() {
+		r.shards[d.To].TxPool.AddRelayTxs(d.From, d.Txs)
+		for i, pd := range r.relays {
+			if pd == d {
+				r.relays = append(r.relays[:i], r.relays[i+1:]...)
+				break
+			}
 		}
-		writer.Write(row)
-	}
+	})
 }
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// MinerStrategy 矿工从交易池挑选交易打包区块的策略。任何策略都不会打包加了税/补贴后收益为负、
+// 或 EIP-1559 下出价低于 base fee 的交易，同一账户的交易总是按 nonce 顺序打包
+type MinerStrategy interface {
+	Name() string
+	Pack(pool *TxPool, limit BlockLimit, tp *TaxPool) []*Transaction
+}
+
+// MinerStrategyFactory 由参数构造一个策略实例，每个分片各持有一个实例
+type MinerStrategyFactory func(params PolicyParams, cfg *Config, shardID uint64) (MinerStrategy, error)
+
+var minerStrategies = map[string]MinerStrategyFactory{}
+
+// RegisterMinerStrategy 按名字注册矿工策略，新策略在 init 中注册即可被配置选用
+func RegisterMinerStrategy(name string, factory MinerStrategyFactory) {
+	if _, dup := minerStrategies[name]; dup {
+		panic("重复注册 MinerStrategy: " + name)
+	}
+	minerStrategies[name] = factory
+}
+
+// NewMinerStrategy 按名字和参数构造分片 shardID 的策略实例
+func NewMinerStrategy(name string, params PolicyParams, cfg *Config, shardID uint64) (MinerStrategy, error) {
+	factory, ok := minerStrategies[name]
+	if !ok {
+		return nil, fmt.Errorf("未知的 miner: %q，可用: %s", name, strings.Join(MinerStrategyNames(), ", "))
+	}
+	miner, err := factory(params, cfg, shardID)
+	if err != nil {
+		return nil, fmt.Errorf("miner %s: %v", name, err)
+	}
+	return miner, nil
+}
+
+// MinerStrategyNames 已注册的策略名，按字典序
+func MinerStrategyNames() []string {
+	names := make([]string, 0, len(minerStrategies))
+	for name := range minerStrategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterMinerStrategy("greedy", newGreedyMiner)
+	RegisterMinerStrategy("fifo", newFIFOMiner)
+	RegisterMinerStrategy("random", newRandomMiner)
+	RegisterMinerStrategy("altruistic", newAltruisticMiner)
+	RegisterMinerStrategy("lazy", newLazyMiner)
+}
+
+// blockLimit 按配置得到的区块容量
+func blockLimit(cfg *Config) BlockLimit {
+	return BlockLimit{MaxTxs: cfg.BlockSize, GasLimit: cfg.BlockGasLimit}
+}
+
+// higherProfit 加了税/补贴后收益高的在前
+func higherProfit(a, b *packCandidate) bool {
+	if c := a.profit.Cmp(b.profit); c != 0 {
+		return c > 0
+	}
+	return a.e.seq < b.e.seq
+}
+
+// greedyOrder 贪心排序：按交易数打包时比收益，按 gas 打包时比每 gas 收益
+func greedyOrder(limit BlockLimit) func(a, b *packCandidate) bool {
+	if limit.GasLimit > 0 {
+		return higherProfitPerGas
+	}
+	return higherProfit
+}
+
+// ---------------- greedy ----------------
+
+// greedyMiner 完全逐利的矿工：总是打包收益（按 gas 打包时为每 gas 收益）最高的交易
+type greedyMiner struct{}
+
+func newGreedyMiner(params PolicyParams, cfg *Config, shardID uint64) (MinerStrategy, error) {
+	if err := params.check(); err != nil {
+		return nil, err
+	}
+	return greedyMiner{}, nil
+}
+
+func (greedyMiner) Name() string { return "greedy" }
+
+func (greedyMiner) Pack(pool *TxPool, limit BlockLimit, tp *TaxPool) []*Transaction {
+	if limit.GasLimit > 0 {
+		return pool.PackTxsByGas(limit.GasLimit, tp)
+	}
+	return pool.PackTxs(uint64(limit.MaxTxs), tp)
+}
+
+// ---------------- fifo ----------------
+
+// fifoMiner 不看收益，按交易提出时间先到先打包
+type fifoMiner struct{}
+
+func newFIFOMiner(params PolicyParams, cfg *Config, shardID uint64) (MinerStrategy, error) {
+	if err := params.check(); err != nil {
+		return nil, err
+	}
+	return fifoMiner{}, nil
+}
+
+func (fifoMiner) Name() string { return "fifo" }
+
+func (fifoMiner) Pack(pool *TxPool, limit BlockLimit, tp *TaxPool) []*Transaction {
+	return pool.packBy(limit, tp, &packRule{better: func(a, b *packCandidate) bool {
+		if !a.e.tx.Time.Equal(b.e.tx.Time) {
+			return a.e.tx.Time.Before(b.e.tx.Time)
+		}
+		return a.e.seq < b.e.seq
+	}})
+}
+
+// ---------------- random ----------------
+
+// randomMiner 从可打包的交易中随机挑选，随机数种子为 cfg.Seed + 分片号，保证结果可复现
+type randomMiner struct {
+	src *countingSource
+	rng *rand.Rand
+}
+
+func newRandomMiner(params PolicyParams, cfg *Config, shardID uint64) (MinerStrategy, error) {
+	if err := params.check(); err != nil {
+		return nil, err
+	}
+	src := newCountingSource(cfg.Seed + int64(shardID))
+	return &randomMiner{src: src, rng: rand.New(src)}, nil
+}
+
+func (m *randomMiner) Name() string { return "random" }
+
+// SaveState 随机数源的取数次数
+func (m *randomMiner) SaveState() ([]byte, error) {
+	return encodeGob(m.src.draws)
+}
+
+func (m *randomMiner) LoadState(data []byte) error {
+	var draws uint64
+	if err := decodeGob(data, &draws); err != nil {
+		return err
+	}
+	return m.src.skipTo(draws)
+}
+
+func (m *randomMiner) Pack(pool *TxPool, limit BlockLimit, tp *TaxPool) []*Transaction {
+	return pool.packBy(limit, tp, &packRule{rng: m.rng, better: func(a, b *packCandidate) bool {
+		if a.rnd != b.rnd {
+			return a.rnd < b.rnd
+		}
+		return a.e.seq < b.e.seq
+	}})
+}
+
+// ---------------- altruistic ----------------
+
+// altruisticMiner 部分利他的矿工：先把区块的 ctxShare（按交易数或 gas）留给跨分片交易，
+// 在其中贪心地打包 CTX，剩余空间再与 ITX 一起贪心打包
+type altruisticMiner struct {
+	ctxShare float64
+}
+
+func newAltruisticMiner(params PolicyParams, cfg *Config, shardID uint64) (MinerStrategy, error) {
+	if err := params.check("ctxShare"); err != nil {
+		return nil, err
+	}
+	m := &altruisticMiner{ctxShare: params.Float("ctxShare", 0.2)}
+	if m.ctxShare < 0 || m.ctxShare > 1 {
+		return nil, fmt.Errorf("ctxShare 须在 [0, 1] 内: %g", m.ctxShare)
+	}
+	return m, nil
+}
+
+func (m *altruisticMiner) Name() string { return "altruistic" }
+
+func (m *altruisticMiner) Pack(pool *TxPool, limit BlockLimit, tp *TaxPool) []*Transaction {
+	reserved := BlockLimit{MaxTxs: int(float64(limit.MaxTxs) * m.ctxShare)}
+	if limit.GasLimit > 0 {
+		reserved.GasLimit = int64(float64(limit.GasLimit) * m.ctxShare)
+	}
+	var txs []*Transaction
+	if reserved.MaxTxs > 0 || reserved.GasLimit > 0 {
+		txs = pool.packBy(reserved, tp, &packRule{
+			better:   greedyOrder(limit),
+			eligible: func(c *packCandidate) bool { return c.e.tx.isCTX },
+		})
+	}
+
+	rest := BlockLimit{MaxTxs: limit.MaxTxs - len(txs)}
+	if limit.GasLimit > 0 {
+		rest.GasLimit = limit.GasLimit
+		for _, tx := range txs {
+			rest.GasLimit -= legGas(tx).Int64()
+		}
+		if rest.GasLimit <= 0 {
+			return txs
+		}
+	} else if rest.MaxTxs <= 0 {
+		return txs
+	}
+	return append(txs, greedyMiner{}.Pack(pool, rest, tp)...)
+}
+
+// ---------------- lazy ----------------
+
+// lazyMiner 嫌跨分片交易麻烦的矿工：CTX 加了补贴后的收益不低于 margin (wei) 才打包，ITX 照常贪心打包
+type lazyMiner struct {
+	margin *big.Int
+}
+
+func newLazyMiner(params PolicyParams, cfg *Config, shardID uint64) (MinerStrategy, error) {
+	if err := params.check("margin"); err != nil {
+		return nil, err
+	}
+	m := &lazyMiner{margin: params.BigInt("margin", 100000000000000)} // 10^14
+	if m.margin.Sign() < 0 {
+		return nil, fmt.Errorf("margin 不能为负: %s", m.margin)
+	}
+	return m, nil
+}
+
+func (m *lazyMiner) Name() string { return "lazy" }
+
+func (m *lazyMiner) Pack(pool *TxPool, limit BlockLimit, tp *TaxPool) []*Transaction {
+	return pool.packBy(limit, tp, &packRule{
+		better: greedyOrder(limit),
+		eligible: func(c *packCandidate) bool {
+			return !c.e.tx.isCTX || c.profit.Cmp(m.margin) >= 0
+		},
+	})
+}
+
+// MinerConfig 矿工策略名和参数
+type MinerConfig struct {
+	Strategy string       `json:"strategy"` // 见 MinerStrategyNames
+	Params   PolicyParams `json:"params"`   // 如 altruistic 的 ctxShare、lazy 的 margin
+}
+
+// MinerFor 分片 shardID 使用的矿工策略：shardMiners 中单独配置的优先，否则用 miner
+func (c *Config) MinerFor(shardID uint64) MinerConfig {
+	if mc, ok := c.ShardMiners[int(shardID)]; ok {
+		return mc
+	}
+	return c.Miner
+}
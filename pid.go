@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+func init() {
+	RegisterTaxPolicy("pid", newPolicyPID)
+}
+
+// ---------------- pid ----------------
+
+// policyPID 两个 PID 回路同时工作，输出是相对 0 的绝对量而不是每块的步长：
+// 时延回路以 Diff_withsign 为误差，输出 u_d 按 adjustForDelay 的比例给 Tax + u_d*(n-1)、Subsidy + u_d；
+// 平衡回路以 Balance 为误差，输出 u_b 给 Tax - u_b、Subsidy + u_b（税池有盈余时减税加补贴）。
+// 稳态由积分项维持，积分项的贡献限制在 ±iLimit 内（anti-windup），到达上限后不再沿同方向累积
+type policyPID struct {
+	shardNum int
+	delay    pidLoop
+	balance  pidLoop
+	last     PIDStats // 最近一次 Next 各项的贡献，供出块统计
+}
+
+var pidParamNames = []string{
+	"kpDelay", "kiDelay", "kdDelay", "iLimitDelay",
+	"kpBalance", "kiBalance", "kdBalance", "iLimitBalance",
+}
+
+func newPolicyPID(params PolicyParams, cfg *Config) (TaxPolicy, error) {
+	if err := params.check(pidParamNames...); err != nil {
+		return nil, err
+	}
+	p := &policyPID{
+		shardNum: cfg.ShardNum,
+		delay: pidLoop{
+			kp:     params.Float("kpDelay", 0.01),
+			ki:     params.Float("kiDelay", 0.002),
+			kd:     params.Float("kdDelay", 0),
+			iLimit: params.Float("iLimitDelay", 1e15),
+		},
+		balance: pidLoop{
+			kp:     params.Float("kpBalance", 0.001),
+			ki:     params.Float("kiBalance", 0.0001),
+			kd:     params.Float("kdBalance", 0),
+			iLimit: params.Float("iLimitBalance", 1e15),
+		},
+	}
+	for _, c := range []struct {
+		name string
+		l    pidLoop
+	}{{"Delay", p.delay}, {"Balance", p.balance}} {
+		if c.l.kp < 0 || c.l.ki < 0 || c.l.kd < 0 {
+			return nil, fmt.Errorf("kp%s、ki%s、kd%s 不能为负", c.name, c.name, c.name)
+		}
+		if c.l.iLimit <= 0 {
+			return nil, fmt.Errorf("iLimit%s 必须为正数: %g", c.name, c.l.iLimit)
+		}
+	}
+	return p, nil
+}
+
+func (p *policyPID) Name() string { return "pid" }
+
+func (p *policyPID) Next(tp *TaxPool, txs []*Transaction) (*big.Int, *big.Int) {
+	dp, di, dd := p.delay.update(bigToFloat(tp.Diff_withsign))
+	bp, bi, bd := p.balance.update(bigToFloat(tp.Balance))
+	p.last = PIDStats{
+		Delay:   PIDTerms{P: floatToBig(dp).String(), I: floatToBig(di).String(), D: floatToBig(dd).String()},
+		Balance: PIDTerms{P: floatToBig(bp).String(), I: floatToBig(bi).String(), D: floatToBig(bd).String()},
+	}
+
+	ud, ub := floatToBig(dp+di+dd), floatToBig(bp+bi+bd)
+	tax := new(big.Int).Mul(ud, big.NewInt(int64(p.shardNum-1)))
+	tax.Sub(tax, ub)
+	subsidy := new(big.Int).Add(ud, ub)
+	return tax, subsidy
+}
+
+// pidState 两个回路的积分和上一块误差，随 checkpoint 保存
+type pidState struct {
+	Delay, Balance pidLoopState
+}
+
+type pidLoopState struct {
+	Integral, Prev float64
+	Started        bool
+}
+
+func (p *policyPID) SaveState() ([]byte, error) {
+	return encodeGob(pidState{Delay: p.delay.state(), Balance: p.balance.state()})
+}
+
+func (p *policyPID) LoadState(data []byte) error {
+	var st pidState
+	if err := decodeGob(data, &st); err != nil {
+		return err
+	}
+	p.delay.setState(st.Delay)
+	p.balance.setState(st.Balance)
+	return nil
+}
+
+// pidLoop 一个位置式 PID 回路，每块更新一次，误差和输出都以 wei 为单位
+type pidLoop struct {
+	kp, ki, kd float64
+	iLimit     float64 // 积分项贡献 |ki*integral| 的上限
+
+	integral float64 // 误差累计
+	prev     float64 // 上一块的误差，第一块没有微分项
+	started  bool
+}
+
+// update 输入本块误差，返回 P、I、D 三项的贡献
+func (l *pidLoop) update(e float64) (p, i, d float64) {
+	p = l.kp * e
+	if l.started {
+		d = l.kd * (e - l.prev)
+	}
+	l.prev, l.started = e, true
+
+	l.integral += e
+	if l.ki > 0 {
+		bound := l.iLimit / l.ki
+		l.integral = math.Max(-bound, math.Min(bound, l.integral))
+	} else {
+		l.integral = 0
+	}
+	i = l.ki * l.integral
+	return p, i, d
+}
+
+func (l *pidLoop) state() pidLoopState {
+	return pidLoopState{Integral: l.integral, Prev: l.prev, Started: l.started}
+}
+
+func (l *pidLoop) setState(st pidLoopState) {
+	l.integral, l.prev, l.started = st.Integral, st.Prev, st.Started
+}
+
+// PIDStats pid 算法给出下一块 Tax/Subsidy 时两个回路 P、I、D 各项的贡献 (wei)，其他算法为空
+type PIDStats struct {
+	Delay   PIDTerms `json:"delay"`
+	Balance PIDTerms `json:"balance"`
+}
+
+type PIDTerms struct {
+	P string `json:"p"`
+	I string `json:"i"`
+	D string `json:"d"`
+}
+
+// pidHeader 与 PIDStats.CSVCells 对应
+func pidHeader() []string {
+	return []string{
+		"PID Delay P", "PID Delay I", "PID Delay D",
+		"PID Balance P", "PID Balance I", "PID Balance D",
+	}
+}
+
+func (ps PIDStats) CSVCells() []string {
+	return []string{ps.Delay.P, ps.Delay.I, ps.Delay.D, ps.Balance.P, ps.Balance.I, ps.Balance.D}
+}
+
+// bigToFloat 控制器内部用 float64 计算，wei 量级在 float64 精度内
+func bigToFloat(x *big.Int) float64 {
+	f, _ := new(big.Float).SetInt(x).Float64()
+	return f
+}
+
+// floatToBig 向零取整转回 wei
+func floatToBig(f float64) *big.Int {
+	n, _ := new(big.Float).SetFloat64(f).Int(nil)
+	return n
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// 持续同号的误差下积分项的贡献不超过 iLimit，误差反号后能立即回落，而不是先抵消累计的超额积分
+func TestPIDAntiWindup(t *testing.T) {
+	cases := []struct {
+		name   string
+		loop   pidLoop
+		errs   []float64
+		wantI  []float64 // 每步的 I 贡献
+		wantPD [][2]float64
+	}{
+		{
+			name:   "积分在上限处饱和",
+			loop:   pidLoop{ki: 1, iLimit: 25},
+			errs:   []float64{10, 10, 10, 10},
+			wantI:  []float64{10, 20, 25, 25},
+			wantPD: [][2]float64{{0, 0}, {0, 0}, {0, 0}, {0, 0}},
+		},
+		{
+			name:  "反号后立即回落",
+			loop:  pidLoop{ki: 1, iLimit: 25},
+			errs:  []float64{100, 100, -10},
+			wantI: []float64{25, 25, 15},
+		},
+		{
+			name:  "负方向饱和",
+			loop:  pidLoop{ki: 0.5, iLimit: 10},
+			errs:  []float64{-30, -30, 4},
+			wantI: []float64{-10, -10, -8},
+		},
+		{
+			name:  "ki 为 0 时没有积分",
+			loop:  pidLoop{iLimit: 25},
+			errs:  []float64{10, 10},
+			wantI: []float64{0, 0},
+		},
+		{
+			name:   "P 与 D，第一块没有微分项",
+			loop:   pidLoop{kp: 2, kd: 3, iLimit: 1},
+			errs:   []float64{5, 7, 4},
+			wantI:  []float64{0, 0, 0},
+			wantPD: [][2]float64{{10, 0}, {14, 6}, {8, -9}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			l := c.loop
+			for k, e := range c.errs {
+				p, i, d := l.update(e)
+				if math.Abs(i-c.wantI[k]) > 1e-9 {
+					t.Errorf("第 %d 步 I = %g, want %g", k, i, c.wantI[k])
+				}
+				if math.Abs(i) > l.iLimit+1e-9 {
+					t.Errorf("第 %d 步 |I| = %g 超过 iLimit %g", k, math.Abs(i), l.iLimit)
+				}
+				if c.wantPD != nil && (p != c.wantPD[k][0] || d != c.wantPD[k][1]) {
+					t.Errorf("第 %d 步 (P, D) = (%g, %g), want %v", k, p, d, c.wantPD[k])
+				}
+			}
+		})
+	}
+}
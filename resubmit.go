@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"math/rand"
+	"time"
+)
+
+// ResubmitConfig 用户对卡在交易池中的交易的反应。每次出块后，进池（或上次重发）超过 StuckMs 仍未打包的账户队首交易，
+// 以 BumpProb 的概率把 GasPrice 提高 BumpPercent% 重发，否则以 CancelProb 的概率发一笔同 nonce、
+// GasPrice 同样提高的零金额转给自己的交易取消原交易。StuckMs 为 0 时关闭
+type ResubmitConfig struct {
+	StuckMs     int64   `json:"stuckMs"`
+	BumpProb    float64 `json:"bumpProb"`
+	CancelProb  float64 `json:"cancelProb"`
+	BumpPercent int64   `json:"bumpPercent"`
+}
+
+func DefaultResubmitConfig() ResubmitConfig {
+	return ResubmitConfig{BumpProb: 0.5, CancelProb: 0.1, BumpPercent: 10}
+}
+
+func (rc ResubmitConfig) Enabled() bool {
+	return rc.StuckMs > 0
+}
+
+func (rc ResubmitConfig) Validate() error {
+	var errs []error
+	if rc.StuckMs < 0 || rc.BumpPercent < 0 {
+		errs = append(errs, errors.New("resubmit stuckMs 和 bumpPercent 不能为负"))
+	}
+	if rc.BumpProb < 0 || rc.CancelProb < 0 || rc.BumpProb+rc.CancelProb > 1 {
+		errs = append(errs, errors.New("resubmit bumpProb、cancelProb 须非负且之和不超过 1"))
+	}
+	return errors.Join(errs...)
+}
+
+// resubmitStuck 模拟 pool 中卡住交易的发送方在 now 时刻提价重发或取消，交易提出时间沿用原交易
+func resubmitStuck(pool *TxPool, rc ResubmitConfig, now time.Time, rng *rand.Rand) {
+	for _, tx := range pool.StuckTxs(now, time.Duration(rc.StuckMs)*time.Millisecond) {
+		r := rng.Float64()
+		if r >= rc.BumpProb+rc.CancelProb {
+			continue
+		}
+		re := *tx
+		re.TxHash = nil
+		re.GasPrice = bumpPrice(tx.GasPrice, rc.BumpPercent)
+		if tx.MaxFeePerGas != nil {
+			re.MaxFeePerGas = bumpPrice(tx.MaxFeePerGas, rc.BumpPercent)
+			re.MaxPriorityFeePerGas = bumpPrice(tx.MaxPriorityFeePerGas, rc.BumpPercent)
+		}
+		if r >= rc.BumpProb {
+			re.Recipient = tx.Sender
+			re.Value = new(big.Int)
+			re.GasUsed = big.NewInt(21000)
+			re.isCTX, re.Broker = false, ""
+		}
+		pool.Resubmit(&re, now)
+	}
+}
+
+// bumpPrice price 上涨 percent%，向上取整，保证恰好满足同样涨幅的替换门槛
+func bumpPrice(price *big.Int, percent int64) *big.Int {
+	p := new(big.Int).Mul(price, big.NewInt(100+percent))
+	p.Add(p, big.NewInt(99))
+	return p.Div(p, big.NewInt(100))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"container/heap"
+	"time"
+)
+
+// SimEpoch 模拟时钟起点，所有交易/区块时间戳都是 SimEpoch + 虚拟时长，与宿主机运行快慢无关
+var SimEpoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// 同一时刻多个事件的处理顺序：先到达交易，再投递 relay，最后出块
+const (
+	PrioTxArrival = iota
+	PrioRelayDelivery
+	PrioBlock
+)
+
+// Event 离散事件：在 At 时刻执行 Fire
+type Event struct {
+	At       time.Time
+	Priority int
+	seq      uint64 // 同时刻同优先级按调度先后执行，保证可复现
+	Fire     func()
+}
+
+type eventQueue []*Event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if !q[i].At.Equal(q[j].At) {
+		return q[i].At.Before(q[j].At)
+	}
+	if q[i].Priority != q[j].Priority {
+		return q[i].Priority < q[j].Priority
+	}
+	return q[i].seq < q[j].seq
+}
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x any)   { *q = append(*q, x.(*Event)) }
+func (q *eventQueue) Pop() any {
+	old := *q
+	n := len(old)
+	ev := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return ev
+}
+
+// Scheduler 离散事件调度器，维护模拟时钟并按时间顺序执行事件
+type Scheduler struct {
+	now     time.Time
+	queue   eventQueue
+	seq     uint64
+	stopped bool
+}
+
+func NewScheduler(start time.Time) *Scheduler {
+	return &Scheduler{now: start}
+}
+
+// Now 当前模拟时间
+func (s *Scheduler) Now() time.Time {
+	return s.now
+}
+
+// Schedule 在 at 时刻调度事件，at 早于当前时间时按当前时间处理
+func (s *Scheduler) Schedule(at time.Time, priority int, fire func()) {
+	if at.Before(s.now) {
+		at = s.now
+	}
+	s.seq++
+	heap.Push(&s.queue, &Event{At: at, Priority: priority, seq: s.seq, Fire: fire})
+}
+
+// After 在当前时间 d 之后调度事件
+func (s *Scheduler) After(d time.Duration, priority int, fire func()) {
+	s.Schedule(s.now.Add(d), priority, fire)
+}
+
+// Stop 停止调度，剩余事件丢弃
+func (s *Scheduler) Stop() {
+	s.stopped = true
+}
+
+// Run 依次执行事件直到队列为空或被 Stop
+func (s *Scheduler) Run() {
+	for !s.stopped && s.queue.Len() > 0 {
+		ev := heap.Pop(&s.queue).(*Event)
+		s.now = ev.At
+		ev.Fire()
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+)
+
+// Shard 单个分片的模拟状态：各自独立的交易池、税池和区块高度
+type Shard struct {
+	ID       uint64
+	TxPool   *TxPool
+	TaxPool  *TaxPool
+	Policy   TaxPolicy       // 本分片的税池调节算法实例
+	Miner    MinerStrategy   // 本分片矿工的打包策略
+	blockNum int             // 下一个要出的区块高度
+	prevEnd  time.Time       // 上一个区块打包结束时间
+	relayOut []*Transaction  // 本分片已打包 relay1、待发往目的分片的 relay2 交易
+	details  []TxDetail      // 本块最终上链交易的明细，仅在 cfg.TxDetails 时记录
+	baseFee  *big.Int        // EIP-1559 模型下下一个区块的 base fee，legacy 为 nil
+	burned   *big.Int        // 累计销毁的 base fee
+	solver   *clearingSolver // cfg.SolverBenchmark 时求解出清的 Tax/Subsidy 作对照
+	cfg      *Config
+	logChan  chan<- string
+}
+
+func NewShard(id uint64, cfg *Config, logChan chan<- string) *Shard {
+	policy, err := NewTaxPolicy(cfg.Policy, cfg.PolicyParams, cfg)
+	if err != nil {
+		log.Panic(err)
+	}
+	mc := cfg.MinerFor(id)
+	miner, err := NewMinerStrategy(mc.Strategy, mc.Params, cfg, id)
+	if err != nil {
+		log.Panic(err)
+	}
+	taxPool := NewTaxPool(cfg, logChan)
+	s := &Shard{
+		ID:       id,
+		TxPool:   NewBoundedTxPool(cfg.PoolCapacity, cfg.TxTTL(), cfg.PriceBumpPercent, taxPool),
+		TaxPool:  taxPool,
+		Policy:   policy,
+		Miner:    miner,
+		blockNum: 1,
+		burned:   big.NewInt(0),
+		cfg:      cfg,
+		logChan:  logChan,
+	}
+	if pa, ok := policy.(PoolAware); ok {
+		pa.BindPool(s.TxPool)
+	}
+	if cfg.SolverBenchmark {
+		if sp, ok := policy.(*policySolver); ok {
+			s.solver = sp.clearingSolver
+		} else {
+			cs, err := newClearingSolver(nil, cfg)
+			if err != nil {
+				log.Panic(err)
+			}
+			cs.pool = s.TxPool
+			s.solver = cs
+		}
+	}
+	if cfg.AgingStep > 0 {
+		s.TxPool.SetAging(cfg.AgingStep)
+	}
+	if cfg.FeeModel == FeeModelEIP1559 {
+		s.baseFee = big.NewInt(cfg.FeeMarket.InitialBaseFee)
+		s.TxPool.SetBaseFee(s.baseFee)
+	}
+	return s
+}
+
+// BlockNum 返回该分片已出的区块数
+func (s *Shard) BlockNum() int {
+	return s.blockNum - 1
+}
+
+// TakeRelayTxs 取出上一次出块产生的 relay2 交易，由调用方投递到目的分片
+func (s *Shard) TakeRelayTxs() []*Transaction {
+	out := s.relayOut
+	s.relayOut = nil
+	return out
+}
+
+// TakeTxDetails 取出上一次出块记录的交易明细
+func (s *Shard) TakeTxDetails() []TxDetail {
+	out := s.details
+	s.details = nil
+	return out
+}
+
+// ProduceBlock 在模拟时刻 now 从本分片交易池打包一个区块并更新税池，池空时不出块返回 false
+func (s *Shard) ProduceBlock(now time.Time) (BlockStats, bool) {
+	// 先丢弃已过期的交易，再看是否有交易可打包
+	s.TxPool.Expire(now)
+	if s.TxPool.GetTxQueueLen() == 0 && s.TxPool.GetRelayPoolLen() == 0 {
+		return BlockStats{}, false
+	}
+
+	s.logChan <- fmt.Sprintf("GenerateBlock=> Shard %d Block %d - 当前交易池大小：%d\n", s.ID, s.blockNum, s.TxPool.GetTxQueueLen())
+
+	// 区块在 now 时刻提出并上链
+	start := now
+
+	// 按本分片的矿工策略打包，每次最多 BlockSize 个交易，设置了 gas 上限时改为按 gas 填满区块
+	txs := s.Miner.Pack(s.TxPool, blockLimit(s.cfg), s.TaxPool)
+	for _, tx := range txs {
+		tx.ShardID = s.ID
+		tx.BlockNumber = uint64(s.blockNum)
+	}
+	// 按本块的 Tax/Subsidy 统计留在池中、被税挤出的交易
+	starvation := s.TxPool.UpdateStarvation(now, s.TaxPool)
+
+	// 本块打包时实际使用的 tax/subsidy，更新 taxpool 后就变成下一高度的值了
+	appliedTax := new(big.Int).Set(s.TaxPool.Tax)
+	appliedSubsidy := new(big.Int).Set(s.TaxPool.Subsidy)
+
+	// 本块 gas 用量；EIP-1559 模型下销毁 base fee，并按 gas 用量相对目标的偏离调整下一块的 base fee
+	// 先于更新 taxpool，按交易池求解的算法看到的是下一块的小费
+	gasUsed := int64(0)
+	for _, tx := range txs {
+		gasUsed += legGas(tx).Int64()
+	}
+	utilization := 0.0
+	if s.cfg.BlockGasLimit > 0 {
+		utilization = float64(gasUsed) / float64(s.cfg.BlockGasLimit)
+	}
+	appliedBaseFee := s.baseFee
+	var burned, totalBurned *big.Int
+	if s.baseFee != nil {
+		burned = new(big.Int).Mul(s.baseFee, big.NewInt(gasUsed))
+		s.burned.Add(s.burned, burned)
+		totalBurned = s.burned
+		fm := s.cfg.FeeMarket
+		s.baseFee = nextBaseFee(s.baseFee, gasUsed, fm.Target(s.cfg), fm.ChangeDenominator)
+		s.TxPool.SetBaseFee(s.baseFee)
+	}
+
+	// 更新 taxpool
+	s.TaxPool.UpdateTaxAndSubsidy(s.Policy, txs)
+	// 余额不足时本块每笔 ctx 实际得到的补贴低于 appliedSubsidy，负 Tax 时付给每笔 itx 的也低于 -appliedTax
+	paidTax, paidSubsidy := s.TaxPool.PaidTax, s.TaxPool.PaidSubsidy
+	if paidSubsidy.Cmp(appliedSubsidy) != 0 || paidTax.Cmp(appliedTax) != 0 {
+		s.logChan <- fmt.Sprintf("UpdateTaxAndSubsidy=> Shard %d 区块 %d 税池余额不足，按比例配给：补贴应发 %s，实发 %s；itx 应得 %s，实得 %s",
+			s.ID, s.blockNum, s.TaxPool.SubsidyOwed_i, s.TaxPool.TotalSubsidy_i, s.TaxPool.PayoutOwed_i, s.TaxPool.PayoutPaid_i)
+	}
+	var pid PIDStats
+	if p, ok := s.Policy.(*policyPID); ok {
+		pid = p.last
+	}
+	var step StepStats
+	if sr, ok := s.Policy.(StepReporter); ok {
+		step = sr.TakeStepStats()
+		if s.cfg.StepRule.Rule != StepFixed {
+			s.logChan <- fmt.Sprintf("UpdateTaxAndSubsidy=> Shard %d 区块 %d 步长：时延 %s (x%s)，平衡 %s (x%s)",
+				s.ID, s.blockNum, orDash(step.DelayStep), step.DelayMult, orDash(step.BalanceStep), step.BalanceMult)
+		}
+	}
+	// 出清解作为对照，与本分片算法给出的下一块 Tax/Subsidy 并列输出
+	var solvedTax, solvedSubsidy *big.Int
+	if s.solver != nil {
+		var ok bool
+		if solvedTax, solvedSubsidy, ok = s.solver.Solve(s.TaxPool); !ok {
+			s.logChan <- fmt.Sprintf("UpdateTaxAndSubsidy=> Shard %d 区块 %d 交易池中没有可打包的交易，出清解为空", s.ID, s.blockNum)
+		}
+	}
+
+	end := now
+	interval := time.Duration(0)
+	if !s.prevEnd.IsZero() {
+		interval = start.Sub(s.prevEnd)
+	}
+	s.prevEnd = end
+
+	// relay1（broker1）上链后生成 relay2（broker2）交易发往目的分片；relay2（broker2）上链即跨分片交易最终确认
+	relay1Count, relay2Count := 0, 0
+	ctxLatencySum := time.Duration(0)
+	var itxLatencies, ctxLatencies []time.Duration
+	for _, tx := range txs {
+		if tx.Broker != "" {
+			// broker1、broker2 都是片内交易，各自被收税
+			tax := s.cfg.Solvency.chargedTax(paidTax, minerFee(tx))
+			if tx.Relayed {
+				relay2Count++
+				ctxLatencySum += end.Sub(tx.Time)
+				ctxLatencies = append(ctxLatencies, end.Sub(tx.Time))
+				s.recordDetail(tx, end, appliedBaseFee, new(big.Int).Add(tx.Relay1Tax, tax), nil)
+				continue
+			}
+			relay1Count++
+			brokerTx := *tx
+			brokerTx.Relayed = true
+			brokerTx.Relay1Time = end
+			brokerTx.Relay1Tax = tax
+			s.relayOut = append(s.relayOut, &brokerTx)
+			continue
+		}
+		if !tx.isCTX {
+			itxLatencies = append(itxLatencies, end.Sub(tx.Time))
+			s.recordDetail(tx, end, appliedBaseFee, s.cfg.Solvency.chargedTax(paidTax, minerFee(tx)), nil)
+			continue
+		}
+		if tx.Relayed {
+			relay2Count++
+			ctxLatencySum += end.Sub(tx.Time)
+			ctxLatencies = append(ctxLatencies, end.Sub(tx.Time))
+			s.recordDetail(tx, end, appliedBaseFee, nil, new(big.Int).Add(tx.Relay1Subsidy, paidSubsidy))
+			continue
+		}
+		relay1Count++
+		relayTx := *tx
+		relayTx.Relayed = true
+		relayTx.Relay1Time = end
+		relayTx.Relay1Subsidy = paidSubsidy
+		s.relayOut = append(s.relayOut, &relayTx)
+	}
+	avgCTXLatency := time.Duration(0)
+	if relay2Count > 0 {
+		avgCTXLatency = ctxLatencySum / time.Duration(relay2Count)
+	}
+
+	drops := s.TxPool.TakeDropCounts()
+	replaced, cancelled := s.TxPool.TakeReplaceCounts()
+	tp := s.TaxPool
+	stats := BlockStats{
+		BlockHeight:   s.blockNum,
+		TxPoolSize:    s.TxPool.GetTxQueueLen(),
+		TxCount:       len(txs),
+		Diff:          tp.Diff_withsign.String(),
+		Balance:       tp.Balance.String(),
+		DeltaBalance:  tp.DeltaBalance.String(),
+		Tax:           tp.Tax.String(),
+		Subsidy:       tp.Subsidy.String(),
+		F_itx_min:     safeStr(tp.F_itx_min),
+		F_ctx_min:     safeStr(tp.F_ctx_min),
+		P_itx_min:     safeStr(tp.P_itx_min),
+		P_ctx_min:     safeStr(tp.P_ctx_min),
+		StartTime:     start,
+		EndTime:       end,
+		BlockInterval: interval,
+		ShardID:       s.ID,
+		Relay1Count:   relay1Count,
+		Relay2Count:   relay2Count,
+		RelayPoolSize: s.TxPool.GetRelayPoolLen(),
+		AvgCTXLatency: avgCTXLatency,
+		ITXLatency:    NewLatencyStats(itxLatencies),
+		CTXLatency:    NewLatencyStats(ctxLatencies),
+		QueuedSize:    s.TxPool.GetQueuedLen(),
+		EvictedITX:    drops.EvictedITX,
+		EvictedCTX:    drops.EvictedCTX,
+		ExpiredITX:    drops.ExpiredITX,
+		ExpiredCTX:    drops.ExpiredCTX,
+		Replaced:      replaced,
+		Cancelled:     cancelled,
+		BaseFee:       bigStr(appliedBaseFee),
+		GasUsed:       gasUsed,
+		Burned:        bigStr(burned),
+		TotalBurned:   bigStr(totalBurned),
+		GasLimit:      s.cfg.BlockGasLimit,
+		GasUtil:       utilization,
+		Starvation:    starvation,
+		Miner:         s.Miner.Name(),
+		PID:           pid,
+		Signal:        tp.SignalStats(),
+		Step:          step,
+		Solvency:      tp.SolvencyStats(),
+		SolvedTax:     bigStr(solvedTax),
+		SolvedSubsidy: bigStr(solvedSubsidy),
+	}
+
+	s.logChan <- fmt.Sprintf("GenerateBlock=> Shard %d 完成区块 %d 打包：共 %d 笔交易，relay1 %d 笔，relay2 %d 笔，跨分片交易平均确认时延 %v",
+		s.ID, s.blockNum, len(txs), relay1Count, relay2Count, avgCTXLatency)
+	if drops != (DropCounts{}) {
+		s.logChan <- fmt.Sprintf("GenerateBlock=> Shard %d 区块 %d 前交易池丢弃交易：驱逐 ITX %d / CTX %d 笔，过期 ITX %d / CTX %d 笔",
+			s.ID, s.blockNum, drops.EvictedITX, drops.EvictedCTX, drops.ExpiredITX, drops.ExpiredCTX)
+	}
+	if replaced > 0 || cancelled > 0 {
+		s.logChan <- fmt.Sprintf("GenerateBlock=> Shard %d 区块 %d 前交易池中 %d 笔交易被提价替换，%d 笔被取消",
+			s.ID, s.blockNum, replaced, cancelled)
+	}
+
+	s.blockNum++
+	return stats, true
+}
+
+// recordDetail 记录一笔在本分片最终上链的交易，baseFee 为本块的 base fee
+func (s *Shard) recordDetail(tx *Transaction, commit time.Time, baseFee, tax, subsidy *big.Int) {
+	if !s.cfg.TxDetails {
+		return
+	}
+	d := TxDetail{
+		Sender:           tx.Sender,
+		Recipient:        tx.Recipient,
+		ShardID:          s.ID,
+		SenderShardID:    uint64(Addr2Shard(tx.Sender, s.cfg.ShardNum)),
+		RecipientShardID: uint64(Addr2Shard(tx.Recipient, s.cfg.ShardNum)),
+		BlockNumber:      tx.BlockNumber,
+		Nonce:            tx.Nonce,
+		IsCTX:            tx.isCTX || tx.Broker != "",
+		GasPrice:         tx.GasPrice,
+		GasUsed:          tx.GasUsed,
+		Tax:              tax,
+		Subsidy:          subsidy,
+		BaseFee:          baseFee,
+		EffectiveTip:     tx.EffectiveTip,
+		ProposeTime:      tx.Time,
+		BlockTime:        commit,
+		CommitTime:       commit,
+		ConfirmLatency:   commit.Sub(tx.Time),
+	}
+	if tx.Relayed {
+		if tx.Broker != "" {
+			d.Broker1Time, d.Broker2Time = tx.Relay1Time, commit
+		} else {
+			d.Relay1Time, d.Relay2Time = tx.Relay1Time, commit
+		}
+	}
+	s.details = append(s.details, d)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+)
+
+// 块内手续费信号的估计方式，Diff 由 ITX、CTX 两类的信号相减得到
+const (
+	SignalMin      = "min"      // 块内最低手续费（早期行为），一笔异常交易就可能让 Diff 变号
+	SignalQuantile = "quantile" // 块内手续费的 quantile 分位数（最近秩，第 ceil(quantile*n) 小的值）
+	SignalTrimmed  = "trimmed"  // 两端各去掉 trim 比例后的均值
+)
+
+// SignalConfig 手续费信号的估计与平滑。alpha 大于 0 时在估计值之上做跨区块的 EWMA：
+// s = alpha*本块估计值 + (1-alpha)*s，某类交易没有出现在本块时其平滑值保持不变
+type SignalConfig struct {
+	Estimator string  `json:"estimator"` // min | quantile | trimmed
+	Quantile  float64 `json:"quantile"`  // quantile 的分位点，如 0.05、0.1
+	Trim      float64 `json:"trim"`      // trimmed 两端各去掉的比例
+	Alpha     float64 `json:"alpha"`     // EWMA 中本块估计值的权重，0 为不平滑
+}
+
+func DefaultSignalConfig() SignalConfig {
+	return SignalConfig{Estimator: SignalMin, Quantile: 0.1, Trim: 0.1}
+}
+
+func (sc SignalConfig) Validate() error {
+	var errs []error
+	switch sc.Estimator {
+	case SignalMin, SignalQuantile, SignalTrimmed:
+	default:
+		errs = append(errs, fmt.Errorf("未知的 signal estimator: %q，可用: min, quantile, trimmed", sc.Estimator))
+	}
+	if sc.Quantile < 0 || sc.Quantile > 1 {
+		errs = append(errs, fmt.Errorf("signal quantile 须在 [0, 1] 内: %g", sc.Quantile))
+	}
+	if sc.Trim < 0 || sc.Trim >= 0.5 {
+		errs = append(errs, fmt.Errorf("signal trim 须在 [0, 0.5) 内: %g", sc.Trim))
+	}
+	if sc.Alpha < 0 || sc.Alpha > 1 {
+		errs = append(errs, errors.New("signal alpha 须在 [0, 1] 内"))
+	}
+	return errors.Join(errs...)
+}
+
+// estimate 按估计方式由一类交易的手续费得到本块的信号，fees 为空时返回 nil。会对 fees 排序
+func (sc SignalConfig) estimate(fees []*big.Int) *big.Int {
+	if len(fees) == 0 {
+		return nil
+	}
+	sort.Slice(fees, func(i, j int) bool { return fees[i].Cmp(fees[j]) < 0 })
+	switch sc.Estimator {
+	case SignalQuantile:
+		// 最近秩，与 NewLatencyStats 一致：第 ceil(q*n) 小的值；减去 1e-9 避免 0.3*10 这类乘积的二进制误差多进一位
+		k := int(math.Ceil(sc.Quantile*float64(len(fees)) - 1e-9))
+		if k < 1 {
+			k = 1
+		}
+		return new(big.Int).Set(fees[k-1])
+	case SignalTrimmed:
+		cut := int(sc.Trim * float64(len(fees)))
+		kept := fees[cut : len(fees)-cut]
+		sum := new(big.Int)
+		for _, f := range kept {
+			sum.Add(sum, f)
+		}
+		return sum.Quo(sum, big.NewInt(int64(len(kept))))
+	}
+	return new(big.Int).Set(fees[0])
+}
+
+// smooth 用本块估计值 raw 更新平滑值 prev，raw 为 nil（本块没有这类交易）时 prev 不变，第一次出现时直接取 raw
+func (sc SignalConfig) smooth(prev, raw *big.Int) *big.Int {
+	if raw == nil {
+		return prev
+	}
+	if sc.Alpha <= 0 || prev == nil {
+		return new(big.Int).Set(raw)
+	}
+	s := new(big.Float).Mul(big.NewFloat(sc.Alpha), new(big.Float).SetInt(raw))
+	s.Add(s, new(big.Float).Mul(big.NewFloat(1-sc.Alpha), new(big.Float).SetInt(prev)))
+	// 四舍五入，避免 alpha 的二进制误差让结果比应有值少 1 wei
+	if s.Sign() >= 0 {
+		s.Add(s, big.NewFloat(0.5))
+	} else {
+		s.Sub(s, big.NewFloat(0.5))
+	}
+	n, _ := s.Int(nil)
+	return n
+}
+
+// SignalStats 出块统计中的手续费信号，块中没有某类交易时对应的列为空；平滑后的 Diff 即 Diff 列
+type SignalStats struct {
+	RawITX      string `json:"rawITX"`
+	RawCTX      string `json:"rawCTX"`
+	RawDiff     string `json:"rawDiff"`
+	SmoothedITX string `json:"smoothedITX"`
+	SmoothedCTX string `json:"smoothedCTX"`
+}
+
+// signalHeader 与 SignalStats.CSVCells 对应
+func signalHeader() []string {
+	return []string{"Signal ITX Raw", "Signal CTX Raw", "Diff Raw", "Signal ITX", "Signal CTX"}
+}
+
+func (ss SignalStats) CSVCells() []string {
+	return []string{ss.RawITX, ss.RawCTX, ss.RawDiff, ss.SmoothedITX, ss.SmoothedCTX}
+}
+
+// SignalStats 最新出块区块的手续费信号
+func (tp *TaxPool) SignalStats() SignalStats {
+	return SignalStats{
+		RawITX:      bigStr(tp.Raw_itx),
+		RawCTX:      bigStr(tp.Raw_ctx),
+		RawDiff:     bigStr(tp.Diff_raw),
+		SmoothedITX: bigStr(tp.Signal_itx),
+		SmoothedCTX: bigStr(tp.Signal_ctx),
+	}
+}
+
+// SignalFor 调节算法 policy 使用的信号估计方式：policySignals 中单独配置的优先，否则用 signal
+func (c *Config) SignalFor(policy string) SignalConfig {
+	if sc, ok := c.PolicySignals[policy]; ok {
+		return sc
+	}
+	return c.Signal
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func bigs(xs ...int64) []*big.Int {
+	var out []*big.Int
+	for _, x := range xs {
+		out = append(out, big.NewInt(x))
+	}
+	return out
+}
+
+func TestSignalEstimate(t *testing.T) {
+	ten := []int64{10, 1, 9, 2, 8, 3, 7, 4, 6, 5} // 排序后为 1..10
+	cases := []struct {
+		name string
+		sc   SignalConfig
+		fees []int64
+		want int64
+	}{
+		{"min", SignalConfig{Estimator: SignalMin}, ten, 1},
+		// 最近秩：第 ceil(q*n) 小的值
+		{"quantile 0.1", SignalConfig{Estimator: SignalQuantile, Quantile: 0.1}, ten, 1},
+		{"quantile 0.3 不多进一位", SignalConfig{Estimator: SignalQuantile, Quantile: 0.3}, ten, 3},
+		{"quantile 0.25", SignalConfig{Estimator: SignalQuantile, Quantile: 0.25}, ten, 3},
+		{"quantile 0.5", SignalConfig{Estimator: SignalQuantile, Quantile: 0.5}, ten, 5},
+		{"quantile 1", SignalConfig{Estimator: SignalQuantile, Quantile: 1}, ten, 10},
+		{"quantile 0 取最小值", SignalConfig{Estimator: SignalQuantile, Quantile: 0}, ten, 1},
+		{"quantile 单笔", SignalConfig{Estimator: SignalQuantile, Quantile: 0.9}, []int64{42}, 42},
+		// 两端各去掉 1 笔，(2+...+9)/8 = 5
+		{"trimmed 0.1", SignalConfig{Estimator: SignalTrimmed, Trim: 0.1}, ten, 5},
+		{"trimmed 去掉异常值", SignalConfig{Estimator: SignalTrimmed, Trim: 0.2}, []int64{-1000, 10, 10, 10, 1000}, 10},
+		{"trimmed 0 为均值", SignalConfig{Estimator: SignalTrimmed}, []int64{1, 2, 6}, 3},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.sc.estimate(bigs(c.fees...)); got.Int64() != c.want {
+				t.Errorf("estimate = %s, want %d", got, c.want)
+			}
+		})
+	}
+	if got := (SignalConfig{Estimator: SignalQuantile, Quantile: 0.5}).estimate(nil); got != nil {
+		t.Errorf("没有交易时 estimate = %s, want nil", got)
+	}
+}
+
+func TestSignalSmooth(t *testing.T) {
+	cases := []struct {
+		name      string
+		alpha     float64
+		prev, raw *big.Int
+		want      *big.Int
+	}{
+		{"本块没有这类交易时保持不变", 0.5, big.NewInt(100), nil, big.NewInt(100)},
+		{"第一次出现取估计值", 0.5, nil, big.NewInt(80), big.NewInt(80)},
+		{"alpha 0 不平滑", 0, big.NewInt(100), big.NewInt(80), big.NewInt(80)},
+		{"alpha 0.5", 0.5, big.NewInt(100), big.NewInt(80), big.NewInt(90)},
+		// 0.1*1000 + 0.9*0 在二进制下略小于 100，四舍五入后不少 1
+		{"alpha 0.1 不少 1 wei", 0.1, big.NewInt(0), big.NewInt(1000), big.NewInt(100)},
+		{"负值四舍五入", 0.5, big.NewInt(-3), big.NewInt(-4), big.NewInt(-4)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := SignalConfig{Alpha: c.alpha}.smooth(c.prev, c.raw)
+			if got == nil || got.Cmp(c.want) != 0 {
+				t.Errorf("smooth = %v, want %s", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Sim 一次模拟运行实例：日志、出块统计和交易明细各走自己的通道，互不共享全局状态，
+// 同一进程中可以并行跑多个实例（见 sweep）
+type Sim struct {
+	cfg       *Config
+	sinks     []StatsSink
+	logChan   chan string
+	statsChan chan BlockStats
+	txDetails chan []TxDetail // cfg.TxDetails 为 false 时为 nil
+	brokers   *Brokers        // broker 模式下的 broker 账户，relay 模式下为 nil
+	resume    *Checkpoint     // 不为 nil 时从该 checkpoint 继续运行
+}
+
+// NewSim 按 cfg 构造运行实例，除 cfg.Sinks 外再把统计写到 extraSinks（如 sweep 用来汇总结果的 MemorySink）
+func NewSim(cfg *Config, extraSinks ...StatsSink) *Sim {
+	return &Sim{
+		cfg:       cfg,
+		sinks:     extraSinks,
+		logChan:   make(chan string, 100000),
+		statsChan: make(chan BlockStats, 10000),
+	}
+}
+
+// ResumeFrom 让 Run 从 ck 保存的状态继续，而不是从头开始。日志和 Tx_Details.csv 追加写入，
+// 同一输出目录中原有的内容得以保留；出块统计写到文件名中 {time} 带 _r{轮数} 后缀的新文件
+func (sim *Sim) ResumeFrom(ck *Checkpoint) *Sim {
+	sim.resume = ck
+	return sim
+}
+
+// Run 启动日志、统计写出协程，按配置注入交易、出块，所有输出写完后返回
+func (sim *Sim) Run() error {
+	cfg := sim.cfg
+
+	// 1) 启动日志输出协程
+	if err := os.MkdirAll(filepath.Dir(cfg.LogPath), os.ModePerm); err != nil {
+		return fmt.Errorf("创建日志目录失败: %v", err)
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if sim.resume != nil {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(cfg.LogPath, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	logger := log.New(f, "", log.Ldate|log.Ltime)
+	logDone := make(chan struct{})
+	go func() {
+		for msg := range sim.logChan {
+			logger.Println(msg)
+		}
+		close(logDone)
+	}()
+	// 之后无论成败都要等日志写完
+	defer func() {
+		close(sim.logChan)
+		<-logDone
+	}()
+	sim.logChan <- fmt.Sprintf("运行配置:\n%s", cfg)
+
+	if cfg.CrossShard == CrossShardBroker {
+		if sim.brokers, err = LoadBrokers(cfg.BrokerFile, cfg.BrokerNum); err != nil {
+			return fmt.Errorf("读取 broker 文件失败: %v", err)
+		}
+		sim.logChan <- fmt.Sprintf("Sim=> broker 模式，共 %d 个 broker 账户", sim.brokers.Len())
+	}
+
+	// 2) 构造出块统计 sink，可同时写多个
+	timestamp := runTimestamp()
+	if sim.resume != nil {
+		// 续跑常与原运行写到同一目录，文件名带上起点轮数，避免同一秒内的文件互相覆盖
+		timestamp += fmt.Sprintf("_r%d", sim.resume.Rounds)
+	}
+	sinks := make([]StatsSink, 0, len(cfg.Sinks)+len(sim.sinks))
+	for _, sc := range cfg.Sinks {
+		sink, err := NewStatsSink(sc, cfg, timestamp)
+		if err != nil {
+			closeSinks(sinks)
+			return fmt.Errorf("构造 sink 失败: %v", err)
+		}
+		sinks = append(sinks, sink)
+	}
+	sinks = append(sinks, sim.sinks...)
+
+	var txWriter *TxDetailWriter
+	if cfg.TxDetails {
+		txWriter, err = NewTxDetailWriter(filepath.Join(cfg.OutputDir, "Tx_Details.csv"), sim.resume != nil)
+		if err != nil {
+			closeSinks(sinks)
+			return err
+		}
+		sim.txDetails = make(chan []TxDetail, 10000)
+	}
+
+	// 3) 构造交易负载来源
+	src, err := NewSource(cfg.Source, cfg, sim.logChan)
+	if err != nil {
+		closeSinks(sinks)
+		if txWriter != nil {
+			txWriter.Close()
+		}
+		return fmt.Errorf("构造交易来源失败: %v", err)
+	}
+
+	// 4) 启动统计和交易明细写出协程
+	statsDone := make(chan struct{})
+	go func() {
+		runStatsSinks(sim.statsChan, sinks, sim.logChan)
+		close(statsDone)
+	}()
+	txDetailDone := make(chan struct{})
+	go func() {
+		if txWriter != nil {
+			for details := range sim.txDetails {
+				if err := txWriter.Write(details); err != nil {
+					sim.logChan <- fmt.Sprintf("Sim=> 写出交易明细失败: %v", err)
+				}
+			}
+			if err := txWriter.Close(); err != nil {
+				sim.logChan <- fmt.Sprintf("Sim=> 关闭 Tx_Details.csv 失败: %v", err)
+			}
+		}
+		close(txDetailDone)
+	}()
+
+	// 5) 按模拟时钟注入交易、出块
+	runErr := sim.GenerateBlock(src)
+
+	// 等 statsChan 和 txDetails 全部写完再返回
+	close(sim.statsChan)
+	if sim.txDetails != nil {
+		close(sim.txDetails)
+	}
+	<-statsDone
+	<-txDetailDone
+	return runErr
+}
+
+func closeSinks(sinks []StatsSink) {
+	for _, sink := range sinks {
+		sink.Close()
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatsSink 出块统计的输出目标，由 runStatsSinks 从一次运行的统计通道依次喂入
+type StatsSink interface {
+	Write(stat BlockStats) error
+	Close() error
+}
+
+// SinkConfig 一个输出目标：type 为 csv | jsonl | memory，path 为空时取 outputDir 下的默认文件名。
+// csv 的 path 中 {shard} 会替换为分片号（每个分片一个文件，不含 {shard} 时所有分片写同一个文件），
+// csv/jsonl 的 path 中 {time} 会替换为运行开始时间
+type SinkConfig struct {
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"`
+}
+
+func DefaultSinkConfigs() []SinkConfig {
+	return []SinkConfig{{Type: "csv"}}
+}
+
+func (sc SinkConfig) Validate() error {
+	switch sc.Type {
+	case "csv", "jsonl", "memory":
+		return nil
+	default:
+		return fmt.Errorf("未知的 sink 类型: %q", sc.Type)
+	}
+}
+
+// resolvePath 补全默认路径并替换 {time}
+func (sc SinkConfig) resolvePath(cfg *Config, timestamp string) string {
+	path := sc.Path
+	if path == "" {
+		switch sc.Type {
+		case "csv":
+			path = filepath.Join(cfg.OutputDir, "shard{shard}_{time}.csv")
+		case "jsonl":
+			path = filepath.Join(cfg.OutputDir, "blocks_{time}.jsonl")
+		}
+	}
+	return strings.ReplaceAll(path, "{time}", timestamp)
+}
+
+// NewStatsSink 按配置构造输出目标，timestamp 用于替换路径中的 {time}
+func NewStatsSink(sc SinkConfig, cfg *Config, timestamp string) (StatsSink, error) {
+	switch sc.Type {
+	case "csv":
+		return NewCSVSink(sc.resolvePath(cfg, timestamp)), nil
+	case "jsonl":
+		return NewJSONLSink(sc.resolvePath(cfg, timestamp))
+	case "memory":
+		return NewMemorySink(), nil
+	default:
+		return nil, fmt.Errorf("未知的 sink 类型: %q", sc.Type)
+	}
+}
+
+// runStatsSinks 把 stats 中的每条统计写到所有 sink，stats 关闭后关闭各 sink
+func runStatsSinks(stats <-chan BlockStats, sinks []StatsSink, logChan chan<- string) {
+	for stat := range stats {
+		for _, sink := range sinks {
+			if err := sink.Write(stat); err != nil {
+				logChan <- fmt.Sprintf("runStatsSinks=> 写出区块统计失败: %v", err)
+			}
+		}
+	}
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			logChan <- fmt.Sprintf("runStatsSinks=> 关闭 sink 失败: %v", err)
+		}
+	}
+}
+
+// blockStatsHeader 前 15 列与早期输出一致，plot_tax_metrics_over_blocks.py 按列下标读取，新增列只能追加在末尾
+var blockStatsHeader = func() []string {
+	header := []string{
+		"Block Height", "TxPool Size", "# of all Txs",
+		"Diff", "Balance", "DeltaBalance", "Tax", "Subsidy", "f_itx_min", "f_ctx_min",
+		"P_itx_min", "P_ctx_min", "StartTime", "EndTime", "BlockInterval(ms)",
+		"Shard ID", "Relay1 Count", "Relay2 Count", "RelayPool Size", "Avg CTX Latency(ms)",
+	}
+	header = append(header, latencyHeader("ITX")...)
+	header = append(header, latencyHeader("CTX")...)
+	header = append(header,
+		"Queued Size", "Evicted ITX", "Evicted CTX", "Expired ITX", "Expired CTX",
+		"Replaced", "Cancelled", "Base Fee", "Gas Used", "Burned", "Total Burned",
+		"Gas Limit", "Gas Utilization")
+	header = append(header, starvationHeader()...)
+	header = append(header, "Miner Strategy")
+	header = append(header, pidHeader()...)
+	header = append(header, "Solved Tax", "Solved Subsidy")
+	header = append(header, signalHeader()...)
+	header = append(header, stepHeader()...)
+	return append(header, solvencyHeader()...)
+}()
+
+// CSVRow 与 blockStatsHeader 一一对应，时间为模拟时钟的毫秒时间戳
+func (stat BlockStats) CSVRow() []string {
+	row := []string{
+		fmt.Sprint(stat.BlockHeight),
+		fmt.Sprint(stat.TxPoolSize),
+		fmt.Sprint(stat.TxCount),
+		stat.Diff,
+		stat.Balance,
+		stat.DeltaBalance,
+		stat.Tax,
+		stat.Subsidy,
+		stat.F_itx_min,
+		stat.F_ctx_min,
+		stat.P_itx_min,
+		stat.P_ctx_min,
+		fmt.Sprint(stat.StartTime.UnixMilli()),
+		fmt.Sprint(stat.EndTime.UnixMilli()),
+		fmt.Sprint(stat.BlockInterval.Milliseconds()),
+		fmt.Sprint(stat.ShardID),
+		fmt.Sprint(stat.Relay1Count),
+		fmt.Sprint(stat.Relay2Count),
+		fmt.Sprint(stat.RelayPoolSize),
+		fmt.Sprint(stat.AvgCTXLatency.Milliseconds()),
+	}
+	row = append(row, stat.ITXLatency.CSVCells()...)
+	row = append(row, stat.CTXLatency.CSVCells()...)
+	row = append(row,
+		fmt.Sprint(stat.QueuedSize),
+		fmt.Sprint(stat.EvictedITX),
+		fmt.Sprint(stat.EvictedCTX),
+		fmt.Sprint(stat.ExpiredITX),
+		fmt.Sprint(stat.ExpiredCTX),
+		fmt.Sprint(stat.Replaced),
+		fmt.Sprint(stat.Cancelled),
+		stat.BaseFee,
+		fmt.Sprint(stat.GasUsed),
+		stat.Burned,
+		stat.TotalBurned,
+		fmt.Sprint(stat.GasLimit),
+		gasUtilStr(stat),
+	)
+	row = append(row, stat.Starvation.CSVCells()...)
+	row = append(row, stat.Miner)
+	row = append(row, stat.PID.CSVCells()...)
+	row = append(row, stat.SolvedTax, stat.SolvedSubsidy)
+	row = append(row, stat.Signal.CSVCells()...)
+	row = append(row, stat.Step.CSVCells()...)
+	return append(row, stat.Solvency.CSVCells()...)
+}
+
+// gasUtilStr 按交易数打包时 gas 利用率没有意义，写空串
+func gasUtilStr(stat BlockStats) string {
+	if stat.GasLimit <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.4f", stat.GasUtil)
+}
+
+// CSVSink 写 CSV，路径含 {shard} 时每个分片一个文件，文件在该分片第一条统计到达时创建
+type CSVSink struct {
+	path    string
+	files   map[string]*os.File
+	writers map[string]*csv.Writer
+}
+
+func NewCSVSink(path string) *CSVSink {
+	return &CSVSink{path: path, files: make(map[string]*os.File), writers: make(map[string]*csv.Writer)}
+}
+
+func (s *CSVSink) Write(stat BlockStats) error {
+	path := strings.ReplaceAll(s.path, "{shard}", fmt.Sprint(stat.ShardID))
+	writer, ok := s.writers[path]
+	if !ok {
+		file, err := createFile(path)
+		if err != nil {
+			return err
+		}
+		writer = csv.NewWriter(file)
+		if err := writer.Write(blockStatsHeader); err != nil {
+			return err
+		}
+		s.files[path] = file
+		s.writers[path] = writer
+	}
+	return writer.Write(stat.CSVRow())
+}
+
+func (s *CSVSink) Close() error {
+	var firstErr error
+	for path, writer := range s.writers {
+		writer.Flush()
+		if err := writer.Error(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := s.files[path].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// JSONLSink 每条统计写一行 JSON，所有分片写同一个文件
+type JSONLSink struct {
+	file *os.File
+	enc  *json.Encoder
+}
+
+func NewJSONLSink(path string) (*JSONLSink, error) {
+	file, err := createFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLSink{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func (s *JSONLSink) Write(stat BlockStats) error {
+	return s.enc.Encode(stat)
+}
+
+func (s *JSONLSink) Close() error {
+	return s.file.Close()
+}
+
+// MemorySink 把统计留在内存中，供程序内直接分析
+type MemorySink struct {
+	lock  sync.Mutex
+	stats []BlockStats
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (s *MemorySink) Write(stat BlockStats) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stats = append(s.stats, stat)
+	return nil
+}
+
+func (s *MemorySink) Close() error {
+	return nil
+}
+
+// Stats 返回目前收到的全部统计的副本
+func (s *MemorySink) Stats() []BlockStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]BlockStats(nil), s.stats...)
+}
+
+// createFile 创建文件，所在目录不存在时一并创建
+func createFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("创建目录失败: %v", err)
+	}
+	return os.Create(path)
+}
+
+// runTimestamp 输出文件名中的运行开始时间
+func runTimestamp() string {
+	return time.Now().Format("20060102_150405")
+}
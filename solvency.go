@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// SolvencyConfig 税池的偿付规则和 Tax/Subsidy 的界限，默认全部关闭：
+//   - floor：本块应发的补贴和负 Tax 付给 itx 的部分会让 Balance 低于 minBalance 时，把上一块 Balance 加本块
+//     收入中高出下限的部分按应得金额的比例配给本块的 ctx 和 itx；矿工按税池能支付的 Tax/Subsidy 排序
+//   - taxCap：每笔 itx 实际被收的税不超过其手续费的 taxCap 倍，矿工打包时按封顶后的税计算收益
+//   - nonNegative：调节算法给出的负 Tax、负 Subsidy 截为 0
+type SolvencyConfig struct {
+	Floor       bool    `json:"floor"`
+	MinBalance  int64   `json:"minBalance"`  // 余额下限 (wei)，可为负，即允许的最大赤字
+	TaxCap      float64 `json:"taxCap"`      // 0 为不封顶
+	NonNegative bool    `json:"nonNegative"` // 同时作用于 Tax 和 Subsidy
+}
+
+func (sc SolvencyConfig) Validate() error {
+	if sc.TaxCap < 0 || sc.TaxCap > 1 {
+		return fmt.Errorf("solvency taxCap 须在 [0, 1] 内: %g", sc.TaxCap)
+	}
+	return nil
+}
+
+// chargedTax 税为 tax 时手续费为 fee 的 itx 实际被收的税
+func (sc SolvencyConfig) chargedTax(tax, fee *big.Int) *big.Int {
+	if sc.TaxCap <= 0 || tax.Sign() <= 0 {
+		return tax
+	}
+	limit, _ := new(big.Float).Mul(new(big.Float).SetInt(fee), big.NewFloat(sc.TaxCap)).Int(nil)
+	if limit.Sign() < 0 {
+		limit.SetInt64(0)
+	}
+	if tax.Cmp(limit) > 0 {
+		return limit
+	}
+	return tax
+}
+
+// clamp 开启 nonNegative 时把负的 Tax/Subsidy 截为 0
+func (sc SolvencyConfig) clamp(tax, subsidy *big.Int) (*big.Int, *big.Int) {
+	if !sc.NonNegative {
+		return tax, subsidy
+	}
+	if tax.Sign() < 0 {
+		tax = big.NewInt(0)
+	}
+	if subsidy.Sign() < 0 {
+		subsidy = big.NewInt(0)
+	}
+	return tax, subsidy
+}
+
+// paidRates 在更新 Balance 之前计算区块 txs 中每笔 itx 实际被收的税（封顶前，负值即付给 itx 的部分）和每笔 ctx
+// 实际得到的补贴，未开启 floor 或余额足额时即 Tax、Subsidy
+func (tp *TaxPool) paidRates(txs []*Transaction) (tax, subsidy *big.Int) {
+	if !tp.cfg.Solvency.Floor {
+		return new(big.Int).Set(tp.Tax), new(big.Int).Set(tp.Subsidy)
+	}
+	nITX, nCTX := int64(0), int64(0)
+	taxIn := new(big.Int)
+	for _, tx := range txs {
+		if tx == nil {
+			continue
+		}
+		if tx.isCTX {
+			nCTX++
+		} else {
+			nITX++
+			if tp.Tax.Sign() > 0 {
+				taxIn.Add(taxIn, tp.cfg.Solvency.chargedTax(tp.Tax, minerFee(tx)))
+			}
+		}
+	}
+	return tp.ration(nITX, nCTX, taxIn)
+}
+
+// expectedRates 矿工打包时按其排序、判断收益的每笔 itx 的税和每笔 ctx 的补贴。开启 floor 时按上一块的 itx、ctx
+// 笔数估计本块的应付总额，只计入税池能够支付的部分，与按 paidRates 实际发放的一致；未开启时即 Tax、Subsidy
+func (tp *TaxPool) expectedRates() (tax, subsidy *big.Int) {
+	if !tp.cfg.Solvency.Floor {
+		return tp.Tax, tp.Subsidy
+	}
+	nITX, nCTX := tp.TotalTaxNum.Int64(), tp.TotalSubsidyNum.Int64()
+	taxIn := new(big.Int)
+	if tp.Tax.Sign() > 0 {
+		taxIn.Mul(tp.Tax, big.NewInt(nITX))
+	}
+	return tp.ration(nITX, nCTX, taxIn)
+}
+
+// ration nITX 笔 itx 和 nCTX 笔 ctx 的区块中，负 Tax 付给 itx 的部分和发给 ctx 的补贴合计超出上一块 Balance
+// 加本块收入（itx 的税 taxIn、负 Subsidy 时 ctx 交的部分）中高出 minBalance 的部分时，两者按同一比例缩减
+func (tp *TaxPool) ration(nITX, nCTX int64, taxIn *big.Int) (tax, subsidy *big.Int) {
+	tax, subsidy = new(big.Int).Set(tp.Tax), new(big.Int).Set(tp.Subsidy)
+	available := new(big.Int).Sub(tp.Balance, big.NewInt(tp.cfg.Solvency.MinBalance))
+	available.Add(available, taxIn)
+	owed := new(big.Int)
+	if tax.Sign() < 0 {
+		owed.Sub(owed, new(big.Int).Mul(tax, big.NewInt(nITX)))
+	}
+	if subsidy.Sign() > 0 {
+		owed.Add(owed, new(big.Int).Mul(subsidy, big.NewInt(nCTX)))
+	} else {
+		available.Sub(available, new(big.Int).Mul(subsidy, big.NewInt(nCTX)))
+	}
+	switch {
+	case owed.Sign() == 0 || owed.Cmp(available) <= 0:
+		return tax, subsidy
+	case available.Sign() <= 0:
+		available.SetInt64(0)
+	}
+	if tax.Sign() < 0 {
+		tax.Neg(tax).Mul(tax, available).Quo(tax, owed).Neg(tax)
+	}
+	if subsidy.Sign() > 0 {
+		subsidy.Mul(subsidy, available).Quo(subsidy, owed)
+	}
+	return tax, subsidy
+}
+
+// SolvencyStats 本块 ctx 应得与实得的补贴总额，itx 因 taxCap 少收的税，以及负 Tax 时 itx 应得与实得的总额，均为 wei
+type SolvencyStats struct {
+	SubsidyOwed string `json:"subsidyOwed"`
+	SubsidyPaid string `json:"subsidyPaid"`
+	TaxCapped   string `json:"taxCapped"`
+	PayoutOwed  string `json:"payoutOwed"`
+	PayoutPaid  string `json:"payoutPaid"`
+}
+
+// solvencyHeader 与 SolvencyStats.CSVCells 对应
+func solvencyHeader() []string {
+	return []string{"Subsidy Owed", "Subsidy Paid", "Tax Capped", "ITX Payout Owed", "ITX Payout Paid"}
+}
+
+func (ss SolvencyStats) CSVCells() []string {
+	return []string{ss.SubsidyOwed, ss.SubsidyPaid, ss.TaxCapped, ss.PayoutOwed, ss.PayoutPaid}
+}
+
+// SolvencyStats 最新出块区块的补贴发放和税封顶情况
+func (tp *TaxPool) SolvencyStats() SolvencyStats {
+	return SolvencyStats{
+		SubsidyOwed: bigStr(tp.SubsidyOwed_i),
+		SubsidyPaid: bigStr(tp.TotalSubsidy_i),
+		TaxCapped:   bigStr(tp.TaxCapped_i),
+		PayoutOwed:  bigStr(tp.PayoutOwed_i),
+		PayoutPaid:  bigStr(tp.PayoutPaid_i),
+	}
+}
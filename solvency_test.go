@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+// solvencyBlock nITX 笔手续费为 fee 的 itx 和 nCTX 笔 ctx
+func solvencyBlock(nITX, nCTX int, fee int64) []*Transaction {
+	var txs []*Transaction
+	for i := 0; i < nITX+nCTX; i++ {
+		txs = append(txs, &Transaction{GasPrice: big.NewInt(fee), GasUsed: big.NewInt(1), isCTX: i >= nITX})
+	}
+	return txs
+}
+
+func TestPaidRates(t *testing.T) {
+	cases := []struct {
+		name                  string
+		sc                    SolvencyConfig
+		balance, tax, subsidy int64
+		nITX, nCTX            int
+		fee                   int64
+		wantTax, wantSubsidy  int64
+	}{
+		{"floor 关闭时不配给", SolvencyConfig{}, -1000, -50, 100, 2, 3, 1000, -50, 100},
+		{"余额足额", SolvencyConfig{Floor: true}, 1000, 10, 100, 2, 3, 1000, 10, 100},
+		// 可用 = 100 + 2*10 = 120，3 笔 ctx 应得 300，每笔 40
+		{"补贴按比例配给", SolvencyConfig{Floor: true}, 100, 10, 100, 2, 3, 1000, 10, 40},
+		// taxCap 0.1 时每笔 itx 只收 5，可用 = 100 + 2*5 = 110，每笔 ctx 36
+		{"配给计入封顶后的税", SolvencyConfig{Floor: true, TaxCap: 0.1}, 100, 10, 100, 2, 3, 50, 10, 36},
+		// 应付 = 2*50 + 3*100 = 400，可用 200，都按 1/2 配给
+		{"负 Tax 与补贴同比例配给", SolvencyConfig{Floor: true}, 200, -50, 100, 2, 3, 1000, -25, 50},
+		// 负 Subsidy 时 ctx 交的 3*20 计入可用，应付 2*50 = 100，可用 50 + 60 = 110，足额
+		{"负 Subsidy 的收入可支付负 Tax", SolvencyConfig{Floor: true}, 50, -50, -20, 2, 3, 1000, -50, -20},
+		{"负 Tax 按比例配给", SolvencyConfig{Floor: true}, 30, -50, -20, 2, 0, 1000, -15, -20},
+		{"余额低于下限时不付", SolvencyConfig{Floor: true, MinBalance: 500}, 100, -50, 100, 2, 3, 1000, 0, 0},
+		// 下限为负即允许的赤字
+		{"负下限", SolvencyConfig{Floor: true, MinBalance: -300}, 0, 10, 100, 0, 3, 1000, 10, 100},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Solvency = c.sc
+			tp := NewTaxPool(cfg, make(chan string, 1))
+			tp.Balance, tp.Tax, tp.Subsidy = big.NewInt(c.balance), big.NewInt(c.tax), big.NewInt(c.subsidy)
+			tax, subsidy := tp.paidRates(solvencyBlock(c.nITX, c.nCTX, c.fee))
+			if tax.Int64() != c.wantTax || subsidy.Int64() != c.wantSubsidy {
+				t.Errorf("paidRates = (%s, %s), want (%d, %d)", tax, subsidy, c.wantTax, c.wantSubsidy)
+			}
+		})
+	}
+}
+
+// floor 开启后出块不会让 Balance 低于 minBalance，不论 Tax/Subsidy 的符号
+func TestFloorKeepsBalance(t *testing.T) {
+	cases := []struct {
+		name         string
+		tax, subsidy int64
+	}{
+		{"正 Tax", 10, 300},
+		{"负 Tax", -300, 300},
+		{"负 Tax 负 Subsidy", -300, -10},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Solvency = SolvencyConfig{Floor: true, MinBalance: -100}
+			tp := NewTaxPool(cfg, make(chan string, 1))
+			tp.Balance, tp.Tax, tp.Subsidy = big.NewInt(50), big.NewInt(c.tax), big.NewInt(c.subsidy)
+			tp.UpdateDiffAndBalance(solvencyBlock(4, 5, 1000))
+			if tp.Balance.Cmp(big.NewInt(-100)) < 0 {
+				t.Errorf("Balance = %s, 低于下限 -100", tp.Balance)
+			}
+		})
+	}
+}
+
+// 矿工排序用的补贴按上一块的笔数估计税池能支付的部分
+func TestExpectedRates(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Solvency = SolvencyConfig{Floor: true}
+	tp := NewTaxPool(cfg, make(chan string, 1))
+	tp.Balance, tp.Tax, tp.Subsidy = big.NewInt(100), big.NewInt(10), big.NewInt(100)
+	tp.TotalTaxNum, tp.TotalSubsidyNum = big.NewInt(2), big.NewInt(3)
+	tax, subsidy := tp.expectedRates()
+	if tax.Int64() != 10 || subsidy.Int64() != 40 {
+		t.Errorf("expectedRates = (%s, %s), want (10, 40)", tax, subsidy)
+	}
+
+	cfg.Solvency.Floor = false
+	if _, subsidy := tp.expectedRates(); subsidy.Int64() != 100 {
+		t.Errorf("floor 关闭时 expectedRates subsidy = %s, want 100", subsidy)
+	}
+}
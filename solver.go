@@ -0,0 +1,152 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+func init() {
+	RegisterTaxPolicy("solver", newPolicySolver)
+}
+
+// PoolAware 需要读取本分片交易池的算法实现此接口，分片创建后注入交易池
+type PoolAware interface {
+	BindPool(pool *TxPool)
+}
+
+// clearingSolver 不沿多个区块追踪偏差，而是直接按交易池当前内容求解下一块的 Tax/Subsidy：
+//  1. 不看税/补贴、按完整手续费（按 gas 打包时为每 gas 手续费）给池中参与竞争的交易排序并填满区块，
+//     即时延平衡时应当上链的 k 笔 ITX 和 m 笔 CTX，边际交易的 key 为 itxMin、ctxMin；
+//  2. 边际 ITX 与边际 CTX 收益相等 itxMin - Tax = ctxMin + Subsidy，此时贪心矿工恰好打包这组交易；
+//  3. 按预计的 k、m 收支满足 Tax*k - Subsidy*m = -Balance/horizon，即在 horizon 个区块内把 Balance 拉回 0。
+//
+// 只有一类交易能上链时只用收支约束求该类的 Tax 或 Subsidy，另一项为 0
+type clearingSolver struct {
+	horizon int64 // 0 为只要求下一块收支平衡
+	limit   BlockLimit
+	pool    *TxPool
+}
+
+func newClearingSolver(params PolicyParams, cfg *Config) (*clearingSolver, error) {
+	horizon := params.BigInt("horizon", 10)
+	if !horizon.IsInt64() || horizon.Sign() < 0 {
+		return nil, fmt.Errorf("horizon 不能为负: %s", horizon)
+	}
+	return &clearingSolver{horizon: horizon.Int64(), limit: blockLimit(cfg)}, nil
+}
+
+// Solve 求解下一块的 Tax/Subsidy，交易池中没有可打包的交易时返回 false
+func (cs *clearingSolver) Solve(tp *TaxPool) (tax, subsidy *big.Int, ok bool) {
+	k, m, itxMin, ctxMin := cs.pool.clearingSet(cs.limit)
+	if k == 0 && m == 0 {
+		return nil, nil, false
+	}
+	target := big.NewInt(0)
+	if cs.horizon > 0 {
+		target.Quo(tp.Balance, big.NewInt(cs.horizon))
+		target.Neg(target)
+	}
+	switch {
+	case m == 0:
+		return target.Quo(target, big.NewInt(int64(k))), big.NewInt(0), true
+	case k == 0:
+		return big.NewInt(0), target.Quo(target.Neg(target), big.NewInt(int64(m))), true
+	}
+	spread := new(big.Int).Sub(itxMin, ctxMin) // Tax + Subsidy
+	tax = new(big.Int).Mul(spread, big.NewInt(int64(m)))
+	tax.Add(tax, target).Quo(tax, big.NewInt(int64(k+m)))
+	return tax, spread.Sub(spread, tax), true
+}
+
+// clearingSet 不修改交易池，模拟不计税/补贴、按完整手续费打包一个区块：返回其中的 ITX、CTX 笔数和各自最低的 key。
+// 同一账户仍按 nonce 顺序，出价低于 base fee 的交易不参与
+func (txpool *TxPool) clearingSet(limit BlockLimit) (nITX, nCTX int, itxMin, ctxMin *big.Int) {
+	txpool.lock.Lock()
+	defer txpool.lock.Unlock()
+
+	// 按交易数打包时比完整手续费（CTX 的 key 为手续费/2），按 gas 打包时比每 gas 手续费（CTX 的 key 和 gas 都是一半）
+	candidate := func(e *poolEntry) (*packCandidate, bool) {
+		if e.key.Sign() < 0 {
+			return nil, false
+		}
+		c := &packCandidate{e: e, profit: e.key, gas: legGas(e.tx)}
+		if e.tx.isCTX && limit.GasLimit <= 0 {
+			c.profit = new(big.Int).Lsh(e.key, 1)
+		}
+		return c, true
+	}
+	cands := &packHeap{rule: &packRule{better: greedyOrder(limit)}}
+	var heads []*poolEntry
+	heads = append(heads, txpool.itxs.items...)
+	heads = append(heads, txpool.ctxs.items...)
+	sort.Slice(heads, func(i, j int) bool { return heads[i].seq < heads[j].seq })
+	for _, e := range heads {
+		if c, ok := candidate(e); ok {
+			cands.items = append(cands.items, c)
+		}
+	}
+	heap.Init(cands)
+
+	remaining := limit.GasLimit
+	for cands.Len() > 0 {
+		if limit.GasLimit <= 0 && nITX+nCTX >= limit.MaxTxs {
+			break
+		}
+		c := heap.Pop(cands).(*packCandidate)
+		if limit.GasLimit > 0 {
+			if c.gas.Int64() > remaining {
+				continue
+			}
+			remaining -= c.gas.Int64()
+		}
+		if c.e.tx.isCTX {
+			nCTX++
+			if ctxMin == nil || c.e.key.Cmp(ctxMin) < 0 {
+				ctxMin = c.e.key
+			}
+		} else {
+			nITX++
+			if itxMin == nil || c.e.key.Cmp(itxMin) < 0 {
+				itxMin = c.e.key
+			}
+		}
+		// 同账户的下一笔 pending 交易随之参与竞争
+		if acc := c.e.acc; acc != nil && c.e.tx.Nonce+1 < acc.end {
+			if nc, ok := candidate(acc.txs[c.e.tx.Nonce+1]); ok {
+				heap.Push(cands, nc)
+			}
+		}
+	}
+	return nITX, nCTX, itxMin, ctxMin
+}
+
+// ---------------- solver ----------------
+
+// policySolver 每块按交易池内容求解出清的 Tax/Subsidy，池中没有可打包的交易时沿用当前值
+type policySolver struct {
+	*clearingSolver
+}
+
+func newPolicySolver(params PolicyParams, cfg *Config) (TaxPolicy, error) {
+	if err := params.check("horizon"); err != nil {
+		return nil, err
+	}
+	cs, err := newClearingSolver(params, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &policySolver{cs}, nil
+}
+
+func (p *policySolver) Name() string { return "solver" }
+
+func (p *policySolver) BindPool(pool *TxPool) { p.pool = pool }
+
+func (p *policySolver) Next(tp *TaxPool, txs []*Transaction) (*big.Int, *big.Int) {
+	if tax, subsidy, ok := p.Solve(tp); ok {
+		return tax, subsidy
+	}
+	return new(big.Int).Set(tp.Tax), new(big.Int).Set(tp.Subsidy)
+}
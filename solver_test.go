@@ -0,0 +1,101 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+// poolTx 一笔 gas 为 1 的交易，GasPrice 即手续费
+type poolTx struct {
+	sender string
+	nonce  uint64
+	fee    int64
+	ctx    bool
+}
+
+func newTestPool(txs []poolTx) *TxPool {
+	pool := NewTxPool()
+	for _, p := range txs {
+		pool.AddTx2Pool(&Transaction{Sender: p.sender, Recipient: "r", Nonce: p.nonce,
+			GasPrice: big.NewInt(p.fee), GasUsed: big.NewInt(1), isCTX: p.ctx})
+	}
+	return pool
+}
+
+func TestClearingSet(t *testing.T) {
+	cases := []struct {
+		name           string
+		txs            []poolTx
+		blockSize      int
+		wantK, wantM   int
+		itxMin, ctxMin int64 // -1 为 nil
+	}{
+		// CTX 按完整手续费竞争：100, 90, 80, 60 上链
+		{"按完整手续费填满区块", []poolTx{{"a", 0, 100, false}, {"b", 0, 90, false}, {"c", 0, 10, false},
+			{"d", 0, 80, true}, {"e", 0, 60, true}, {"f", 0, 5, true}}, 4, 2, 2, 90, 30},
+		// a 的 nonce 1 出价最高，但须等 nonce 0 先上链
+		{"同账户按 nonce 顺序", []poolTx{{"a", 0, 10, false}, {"a", 1, 100, false}, {"b", 0, 50, false}}, 2, 2, 0, 10, -1},
+		{"区块未满", []poolTx{{"a", 0, 10, true}}, 4, 0, 1, -1, 5},
+		{"空池", nil, 4, 0, 0, -1, -1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			k, m, itxMin, ctxMin := newTestPool(c.txs).clearingSet(BlockLimit{MaxTxs: c.blockSize})
+			if k != c.wantK || m != c.wantM {
+				t.Fatalf("clearingSet 笔数 = (%d, %d), want (%d, %d)", k, m, c.wantK, c.wantM)
+			}
+			check := func(label string, got *big.Int, want int64) {
+				if (want < 0) != (got == nil) || (got != nil && got.Int64() != want) {
+					t.Errorf("%s = %v, want %d", label, got, want)
+				}
+			}
+			check("itxMin", itxMin, c.itxMin)
+			check("ctxMin", ctxMin, c.ctxMin)
+		})
+	}
+}
+
+func TestClearingSolverSolve(t *testing.T) {
+	mixed := []poolTx{{"a", 0, 100, false}, {"b", 0, 90, false}, {"c", 0, 10, false},
+		{"d", 0, 80, true}, {"e", 0, 60, true}, {"f", 0, 5, true}}
+	cases := []struct {
+		name             string
+		txs              []poolTx
+		horizon, balance int64
+		wantTax, wantSub int64
+		wantOK           bool
+	}{
+		// 边际收益相等 90 - 30 = 30 + 30，收支 30*2 - 30*2 = 0
+		{"下一块收支平衡", mixed, 0, 0, 30, 30, true},
+		// 目标收支 -400/10 = -40：20*2 - 40*2 = -40，且 90 - 20 = 30 + 40
+		{"horizon 内归还余额", mixed, 10, 400, 20, 40, true},
+		{"只有 ITX", []poolTx{{"a", 0, 100, false}, {"b", 0, 90, false}}, 10, 100, -5, 0, true},
+		// 赤字 100 由 2 笔 CTX 在 10 块内补上，每笔交 5
+		{"只有 CTX", []poolTx{{"d", 0, 80, true}, {"e", 0, 60, true}}, 10, -100, 0, -5, true},
+		{"空池沿用当前值", nil, 10, 100, 0, 0, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.BlockSize = 4
+			cs, err := newClearingSolver(PolicyParams{"horizon": float64(c.horizon)}, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cs.pool = newTestPool(c.txs)
+			tp := NewTaxPool(cfg, make(chan string, 1))
+			tp.Balance = big.NewInt(c.balance)
+			tax, subsidy, ok := cs.Solve(tp)
+			if ok != c.wantOK {
+				t.Fatalf("Solve ok = %v, want %v", ok, c.wantOK)
+			}
+			if ok && (tax.Int64() != c.wantTax || subsidy.Int64() != c.wantSub) {
+				t.Errorf("Solve = (%s, %s), want (%d, %d)", tax, subsidy, c.wantTax, c.wantSub)
+			}
+		})
+	}
+
+	if _, err := newClearingSolver(PolicyParams{"horizon": -1}, DefaultConfig()); err == nil {
+		t.Error("horizon 为负时 newClearingSolver 应返回错误")
+	}
+}
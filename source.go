@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Source 交易负载来源，每次注入时调用 NextBatch 取下一批交易，ok=false 表示已耗尽。
+// State/Restore 导出和恢复读取进度，用于 checkpoint：恢复时先按同样的配置重新构造来源，再 Restore
+type Source interface {
+	NextBatch() (txs []*Transaction, ok bool)
+	State() SourceState
+	Restore(st SourceState) error
+}
+
+// SourceState 负载来源的读取进度，各字段只对对应类型的来源有意义
+type SourceState struct {
+	Opened  bool          // sequential：是否已打开文件
+	Done    bool          // sequential：是否已耗尽
+	Index   int           // sequential：下一笔有效交易的序号
+	Offset  int64         // sequential：下一行在文件中的字节偏移，恢复时直接 Seek，不必从头解析
+	Pos     int           // loop：窗口内下一笔的位置
+	Round   int           // loop：已完整循环的次数
+	Current int           // concat：当前子来源的下标
+	Subs    []SourceState // concat：各子来源的进度
+}
+
+// SourceConfig 负载来源配置，concat 可嵌套组合任意来源。
+// 下标均指数据集中有效交易（data2tx 通过的行）的序号，窗口为左闭右开 [Start, End)
+type SourceConfig struct {
+	Type      string         `json:"type"`                // sequential | loop | prefixThenLoop | concat
+	Start     int            `json:"start,omitempty"`     // sequential/loop 窗口，prefixThenLoop 的前缀窗口
+	End       int            `json:"end,omitempty"`       // 为 0 时取 dataTotalNum
+	LoopStart int            `json:"loopStart,omitempty"` // prefixThenLoop 的循环窗口
+	LoopEnd   int            `json:"loopEnd,omitempty"`
+	Repeat    int            `json:"repeat,omitempty"`  // loop 窗口循环次数，0 表示无限循环
+	Sources   []SourceConfig `json:"sources,omitempty"` // concat 依次取完的子来源
+}
+
+// DefaultSourceConfig 先顺序注入前 100w 笔，之后循环注入 100w~101w
+func DefaultSourceConfig() SourceConfig {
+	return SourceConfig{Type: "prefixThenLoop", Start: 0, End: 1000000, LoopStart: 1000000, LoopEnd: 1010000}
+}
+
+func (sc SourceConfig) end(cfg *Config) int {
+	if sc.End == 0 {
+		return cfg.DataTotalNum
+	}
+	return sc.End
+}
+
+// Validate 检查窗口是否合法
+func (sc SourceConfig) Validate(cfg *Config) error {
+	checkWindow := func(name string, start, end int) error {
+		if start < 0 || end <= start {
+			return fmt.Errorf("source %s 窗口不合法: [%d, %d)", name, start, end)
+		}
+		return nil
+	}
+	switch sc.Type {
+	case "sequential":
+		return checkWindow("sequential", sc.Start, sc.end(cfg))
+	case "loop":
+		if sc.Repeat < 0 {
+			return fmt.Errorf("source loop repeat 不能为负: %d", sc.Repeat)
+		}
+		return checkWindow("loop", sc.Start, sc.end(cfg))
+	case "prefixThenLoop":
+		return errors.Join(
+			checkWindow("prefixThenLoop 前缀", sc.Start, sc.end(cfg)),
+			checkWindow("prefixThenLoop 循环", sc.LoopStart, sc.LoopEnd))
+	case "concat":
+		if len(sc.Sources) == 0 {
+			return errors.New("source concat 至少需要一个子来源")
+		}
+		var errs []error
+		for _, sub := range sc.Sources {
+			errs = append(errs, sub.Validate(cfg))
+		}
+		return errors.Join(errs...)
+	default:
+		return fmt.Errorf("未知的 source 类型: %q", sc.Type)
+	}
+}
+
+// NewSource 按配置构造负载来源
+func NewSource(sc SourceConfig, cfg *Config, logChan chan<- string) (Source, error) {
+	switch sc.Type {
+	case "sequential":
+		return NewSequentialSource(cfg.TxsCsvPath, sc.Start, sc.end(cfg), cfg.GlobalBatchSz, logChan)
+	case "loop":
+		return NewLoopSource(cfg.TxsCsvPath, sc.Start, sc.end(cfg), cfg.GlobalBatchSz, sc.Repeat, logChan)
+	case "prefixThenLoop":
+		return NewPrefixThenLoopSource(cfg.TxsCsvPath, sc.Start, sc.end(cfg), sc.LoopStart, sc.LoopEnd, cfg.GlobalBatchSz, logChan)
+	case "concat":
+		subs := make([]Source, 0, len(sc.Sources))
+		for _, subCfg := range sc.Sources {
+			sub, err := NewSource(subCfg, cfg, logChan)
+			if err != nil {
+				return nil, err
+			}
+			subs = append(subs, sub)
+		}
+		return NewConcatSource(subs...), nil
+	default:
+		return nil, fmt.Errorf("未知的 source 类型: %q", sc.Type)
+	}
+}
+
+// txsReader 顺序读取交易 csv 中的有效交易，index 为下一笔有效交易的序号
+type txsReader struct {
+	file   *os.File
+	reader *csv.Reader
+	index  int
+	base   int64 // reader 起始处在文件中的字节偏移
+}
+
+func openTxsReader(path string) (*txsReader, error) {
+	return openTxsReaderAt(path, 0, 0)
+}
+
+// openTxsReaderAt 从字节偏移 offset 处开始读，该处为序号 index 的有效交易所在行或其之前的无效行
+func openTxsReaderAt(path string, offset int64, index int) (*txsReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &txsReader{file: f, reader: csv.NewReader(f), index: index, base: offset}, nil
+}
+
+// offset 下一行在文件中的字节偏移
+func (r *txsReader) offset() int64 {
+	return r.base + r.reader.InputOffset()
+}
+
+// next 读下一笔有效交易，读到文件尾返回 io.EOF
+func (r *txsReader) next() (*Transaction, error) {
+	for {
+		data, err := r.reader.Read()
+		if err != nil {
+			return nil, err
+		}
+		if tx, ok := data2tx(data, uint64(r.index)); ok {
+			r.index++
+			return tx, nil
+		}
+	}
+}
+
+// skipTo 跳过序号小于 start 的交易
+func (r *txsReader) skipTo(start int) error {
+	for r.index < start {
+		if _, err := r.next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *txsReader) Close() error {
+	return r.file.Close()
+}
+
+// loadTxs 一次性读入 [start, end) 的交易，文件不足时读到文件尾为止，读取情况写入 logChan
+func loadTxs(path string, start, end int, logChan chan<- string) ([]*Transaction, error) {
+	r, err := openTxsReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	begin := time.Now()
+	if err := r.skipTo(start); err != nil && err != io.EOF {
+		return nil, err
+	}
+	txs := make([]*Transaction, 0, end-start)
+	for r.index < end {
+		tx, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
+	logChan <- fmt.Sprintf("loadTxs=> 已读取交易 %d ~ %d（共 %d 笔），耗时 %.2f 秒", start, start+len(txs)-1, len(txs), time.Since(begin).Seconds())
+	return txs, nil
+}
+
+// SequentialSource 边读文件边注入 [start, end) 的交易，读完即耗尽
+type SequentialSource struct {
+	path      string
+	start     int
+	end       int
+	batchSize int
+	reader    *txsReader // 第一次取批次时才打开文件
+	done      bool
+	logChan   chan<- string
+}
+
+func NewSequentialSource(path string, start, end, batchSize int, logChan chan<- string) (*SequentialSource, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return &SequentialSource{path: path, start: start, end: end, batchSize: batchSize, logChan: logChan}, nil
+}
+
+func (s *SequentialSource) NextBatch() ([]*Transaction, bool) {
+	if s.done {
+		return nil, false
+	}
+	if s.reader == nil {
+		r, err := openTxsReader(s.path)
+		if err != nil {
+			s.finish(err)
+			return nil, false
+		}
+		s.reader = r
+		if err := r.skipTo(s.start); err != nil {
+			s.finish(err)
+			return nil, false
+		}
+	}
+
+	batch := make([]*Transaction, 0, s.batchSize)
+	for len(batch) < s.batchSize && s.reader.index < s.end {
+		tx, err := s.reader.next()
+		if err != nil {
+			s.finish(err)
+			break
+		}
+		batch = append(batch, tx)
+	}
+	if !s.done && s.reader.index >= s.end {
+		s.finish(nil)
+	}
+	return batch, len(batch) > 0
+}
+
+func (s *SequentialSource) State() SourceState {
+	st := SourceState{Opened: s.reader != nil, Done: s.done}
+	if s.reader != nil {
+		st.Index, st.Offset = s.reader.index, s.reader.offset()
+	}
+	return st
+}
+
+func (s *SequentialSource) Restore(st SourceState) error {
+	s.done = st.Done
+	if st.Done || !st.Opened {
+		return nil
+	}
+	r, err := openTxsReaderAt(s.path, st.Offset, st.Index)
+	if err != nil {
+		return err
+	}
+	s.reader = r
+	return nil
+}
+
+// finish 关闭文件，之后不再有交易
+func (s *SequentialSource) finish(err error) {
+	if err != nil && err != io.EOF {
+		s.logChan <- fmt.Sprintf("SequentialSource=> 读取 %s 失败: %v", s.path, err)
+	}
+	if s.reader != nil {
+		s.reader.Close()
+	}
+	s.done = true
+}
+
+// LoopSource 一次读入 [start, end) 窗口后循环注入，每次注入的是副本；repeat 为 0 时无限循环
+type LoopSource struct {
+	window    []*Transaction
+	batchSize int
+	repeat    int
+	pos       int // 窗口内下一笔的位置
+	round     int // 已完整循环的次数
+}
+
+func NewLoopSource(path string, start, end, batchSize, repeat int, logChan chan<- string) (*LoopSource, error) {
+	window, err := loadTxs(path, start, end, logChan)
+	if err != nil {
+		return nil, err
+	}
+	if len(window) == 0 {
+		return nil, fmt.Errorf("loop 窗口 [%d, %d) 内没有交易", start, end)
+	}
+	return &LoopSource{window: window, batchSize: batchSize, repeat: repeat}, nil
+}
+
+func (s *LoopSource) NextBatch() ([]*Transaction, bool) {
+	batch := make([]*Transaction, 0, s.batchSize)
+	for len(batch) < s.batchSize {
+		if s.repeat > 0 && s.round >= s.repeat {
+			break
+		}
+		cloned := *s.window[s.pos] // 浅拷贝，到达时间由注入时按模拟时钟重新赋值
+		batch = append(batch, &cloned)
+		s.pos++
+		if s.pos == len(s.window) {
+			s.pos = 0
+			s.round++
+		}
+	}
+	return batch, len(batch) > 0
+}
+
+func (s *LoopSource) State() SourceState {
+	return SourceState{Pos: s.pos, Round: s.round}
+}
+
+func (s *LoopSource) Restore(st SourceState) error {
+	if st.Pos < 0 || st.Pos >= len(s.window) {
+		return fmt.Errorf("loop 窗口位置越界: %d", st.Pos)
+	}
+	s.pos, s.round = st.Pos, st.Round
+	return nil
+}
+
+// ConcatSource 依次取完各个子来源
+type ConcatSource struct {
+	sources []Source
+	cur     int // 当前子来源的下标，之前的都已耗尽
+}
+
+func NewConcatSource(sources ...Source) *ConcatSource {
+	return &ConcatSource{sources: sources}
+}
+
+func (s *ConcatSource) NextBatch() ([]*Transaction, bool) {
+	for s.cur < len(s.sources) {
+		if txs, ok := s.sources[s.cur].NextBatch(); ok {
+			return txs, true
+		}
+		s.cur++
+	}
+	return nil, false
+}
+
+func (s *ConcatSource) State() SourceState {
+	st := SourceState{Current: s.cur, Subs: make([]SourceState, len(s.sources))}
+	for i := s.cur; i < len(s.sources); i++ {
+		st.Subs[i] = s.sources[i].State()
+	}
+	return st
+}
+
+func (s *ConcatSource) Restore(st SourceState) error {
+	if len(st.Subs) != len(s.sources) || st.Current < 0 || st.Current > len(s.sources) {
+		return fmt.Errorf("concat 子来源数与 checkpoint 不一致: %d vs %d", len(s.sources), len(st.Subs))
+	}
+	s.cur = st.Current
+	for i := s.cur; i < len(s.sources); i++ {
+		if err := s.sources[i].Restore(st.Subs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewPrefixThenLoopSource 先顺序注入 [start, end)，之后无限循环注入 [loopStart, loopEnd)
+func NewPrefixThenLoopSource(path string, start, end, loopStart, loopEnd, batchSize int, logChan chan<- string) (Source, error) {
+	prefix, err := NewSequentialSource(path, start, end, batchSize, logChan)
+	if err != nil {
+		return nil, err
+	}
+	loop, err := NewLoopSource(path, loopStart, loopEnd, batchSize, 0, logChan)
+	if err != nil {
+		return nil, err
+	}
+	return NewConcatSource(prefix, loop), nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// starvationBuckets 挨饿时长直方图各桶的上界，最后还有一个超过最大上界的桶
+var starvationBuckets = []time.Duration{
+	5 * time.Second, 15 * time.Second, 30 * time.Second, time.Minute, 5 * time.Minute,
+}
+
+// StarvationStats 出块后交易池中被税挤出的交易：不计税/补贴时收益非负（EIP-1559 下即出价不低于 base fee），
+// 计入本块的 Tax/Subsidy 后收益为负、因而不会被打包。挨饿时长从第一次被发现挨饿的区块算起
+type StarvationStats struct {
+	ITX     int           `json:"itx"`
+	CTX     int           `json:"ctx"`     // relay1 和 relay2
+	Hist    []int         `json:"hist"`    // 按 starvationBuckets 分桶的挨饿时长直方图，长度为桶数 + 1
+	MaxAge  time.Duration `json:"maxAge"`  // 最长挨饿时长
+	FeeMean string        `json:"feeMean"` // 挨饿交易不计税/补贴的收益（ITX 为手续费，CTX 为手续费/2）均值，没有挨饿交易时为空
+	FeeMax  string        `json:"feeMax"`
+}
+
+// starvationHeader 与 StarvationStats.CSVCells 对应
+func starvationHeader() []string {
+	header := []string{"Starved ITX", "Starved CTX"}
+	lower := "0s"
+	for _, b := range starvationBuckets {
+		header = append(header, fmt.Sprintf("Starved %s-%s", lower, shortDuration(b)))
+		lower = shortDuration(b)
+	}
+	header = append(header, fmt.Sprintf("Starved >%s", lower))
+	return append(header, "Starved Max Age(ms)", "Starved Fee Mean", "Starved Fee Max")
+}
+
+// shortDuration 5s、1m 这样的简写，用于列名
+func shortDuration(d time.Duration) string {
+	if d%time.Minute == 0 {
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return fmt.Sprintf("%ds", d/time.Second)
+}
+
+func (ss StarvationStats) CSVCells() []string {
+	cells := []string{fmt.Sprint(ss.ITX), fmt.Sprint(ss.CTX)}
+	for i := 0; i <= len(starvationBuckets); i++ {
+		n := 0
+		if i < len(ss.Hist) {
+			n = ss.Hist[i]
+		}
+		cells = append(cells, fmt.Sprint(n))
+	}
+	return append(cells, fmt.Sprint(ss.MaxAge.Milliseconds()), ss.FeeMean, ss.FeeMax)
+}
+
+// starvationBucket d 所在的直方图桶
+func starvationBucket(d time.Duration) int {
+	for i, b := range starvationBuckets {
+		if d < b {
+			return i
+		}
+	}
+	return len(starvationBuckets)
+}
+
+// UpdateStarvation 在 now 时刻按 tp 当前（刚打包的区块使用）的 Tax/Subsidy 统计池中挨饿的交易，
+// 并记下每笔交易连续挨饿的区块数；开启 aging 时挨饿交易排序用的收益随之提高，不再挨饿的交易恢复原值。
+// 挨饿的 ITX 须 base < Tax，CTX 须 base < -Subsidy，只访问 base 堆中低于门槛的部分和上次挨饿的交易，
+// 代价与挨饿交易数成正比
+func (txpool *TxPool) UpdateStarvation(now time.Time, tp *TaxPool) StarvationStats {
+	txpool.lock.Lock()
+	defer txpool.lock.Unlock()
+
+	tax, subsidy := tp.expectedRates()
+	negSubsidy := new(big.Int).Neg(subsidy)
+	sc := tp.cfg.Solvency
+	isStarved := func(e *poolEntry) bool {
+		if e.base.Sign() < 0 {
+			return false
+		}
+		if e.tx.isCTX {
+			return e.base.Cmp(negSubsidy) < 0
+		}
+		return e.base.Cmp(sc.chargedTax(tax, e.base)) < 0
+	}
+
+	var starved []*poolEntry
+	collect := func(h *entryHeap, limit *big.Int) {
+		var walk func(i int)
+		walk = func(i int) {
+			if i >= h.Len() || h.items[i].base.Cmp(limit) >= 0 {
+				return
+			}
+			if e := h.items[i]; isStarved(e) {
+				starved = append(starved, e)
+			}
+			walk(2*i + 1)
+			walk(2*i + 2)
+		}
+		if limit.Sign() > 0 {
+			walk(0)
+		}
+	}
+	collect(&txpool.itxBase, tax)
+	collect(&txpool.ctxBase, negSubsidy)
+
+	// 上次挨饿、仍在池中而本次不再挨饿的交易恢复原值
+	var changed []*poolEntry // starved 有变化、开启 aging 时需要调整 key 的交易
+	for _, e := range txpool.starved {
+		if e.idx[slotBase] >= 0 && e.starved > 0 && !isStarved(e) {
+			e.starved, e.starvedSince = 0, time.Time{}
+			changed = append(changed, e)
+		}
+	}
+
+	ss := StarvationStats{Hist: make([]int, len(starvationBuckets)+1)}
+	feeSum, feeMax := new(big.Int), (*big.Int)(nil)
+	for _, e := range starved {
+		if e.starved == 0 {
+			e.starvedSince = now
+		}
+		e.starved++
+		changed = append(changed, e)
+
+		if e.tx.isCTX {
+			ss.CTX++
+		} else {
+			ss.ITX++
+		}
+		age := now.Sub(e.starvedSince)
+		ss.Hist[starvationBucket(age)]++
+		if age > ss.MaxAge {
+			ss.MaxAge = age
+		}
+		feeSum.Add(feeSum, e.base)
+		if feeMax == nil || e.base.Cmp(feeMax) > 0 {
+			feeMax = e.base
+		}
+	}
+	txpool.starved = starved
+
+	if n := ss.ITX + ss.CTX; n > 0 {
+		ss.FeeMean = feeSum.Div(feeSum, big.NewInt(int64(n))).String()
+		ss.FeeMax = feeMax.String()
+	}
+	if txpool.agingStep > 0 {
+		for _, e := range changed {
+			txpool.rekey(e)
+		}
+	}
+	return ss
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// 步长 Δ 的自适应规则，作用于 v3.x 的时延回路和平衡回路，两个回路各自维护倍率
+const (
+	StepFixed    = "fixed"    // 倍率恒为 1，即原来的固定 Δ（v3.2 起再按偏离乘 factor）
+	StepAIMD     = "aimd"     // 同向调整时倍率加 increase，方向变号时乘 shrink
+	StepRprop    = "rprop"    // 同向调整时倍率乘 grow，方向变号时乘 shrink
+	StepMomentum = "momentum" // v = beta*v + 方向，倍率取 v 在当前方向上的分量
+)
+
+// StepRuleConfig 自适应步长规则。回路的调整方向与上一次调整相反即认为在振荡、缩小步长，
+// 持续朝同一方向调整说明偏差是单边的、放大步长；倍率限制在 [minMult, maxMult] 内
+type StepRuleConfig struct {
+	Rule     string  `json:"rule"`     // fixed | aimd | rprop | momentum
+	Increase float64 `json:"increase"` // aimd 同向时倍率的加量
+	Grow     float64 `json:"grow"`     // rprop 同向时倍率的乘数
+	Shrink   float64 `json:"shrink"`   // aimd、rprop 变号时倍率的乘数
+	Beta     float64 `json:"beta"`     // momentum 的衰减系数，持续同向时倍率趋于 1/(1-beta)
+	MinMult  float64 `json:"minMult"`
+	MaxMult  float64 `json:"maxMult"`
+}
+
+func DefaultStepRuleConfig() StepRuleConfig {
+	return StepRuleConfig{Rule: StepFixed, Increase: 0.5, Grow: 1.2, Shrink: 0.5, Beta: 0.8, MinMult: 0.1, MaxMult: 10}
+}
+
+func (sc StepRuleConfig) Validate() error {
+	var errs []error
+	switch sc.Rule {
+	case StepFixed, StepAIMD, StepRprop, StepMomentum:
+	default:
+		errs = append(errs, fmt.Errorf("未知的 stepRule rule: %q，可用: fixed, aimd, rprop, momentum", sc.Rule))
+	}
+	if sc.Increase < 0 || sc.Grow < 1 || sc.Shrink <= 0 || sc.Shrink > 1 {
+		errs = append(errs, errors.New("stepRule 须满足 increase >= 0, grow >= 1, 0 < shrink <= 1"))
+	}
+	if sc.Beta < 0 || sc.Beta >= 1 {
+		errs = append(errs, fmt.Errorf("stepRule beta 须在 [0, 1) 内: %g", sc.Beta))
+	}
+	if sc.MinMult <= 0 || sc.MinMult > sc.MaxMult {
+		errs = append(errs, fmt.Errorf("stepRule 倍率区间不合法: [%g, %g]", sc.MinMult, sc.MaxMult))
+	}
+	return errors.Join(errs...)
+}
+
+// stepAdapter 一个调节回路的自适应步长倍率
+type stepAdapter struct {
+	rule     StepRuleConfig
+	mult     float64
+	prevDir  int     // 上一次调整的方向 ±1，还没调整过为 0
+	velocity float64 // momentum 的累计方向
+	applied  *big.Int
+}
+
+func newStepAdapter(rule StepRuleConfig) *stepAdapter {
+	return &stepAdapter{rule: rule, mult: 1}
+}
+
+// update 回路本块朝 dir (±1) 方向调整，按规则更新倍率
+func (a *stepAdapter) update(dir int) {
+	r := a.rule
+	flipped := a.prevDir != 0 && dir != a.prevDir
+	sustained := a.prevDir != 0 && dir == a.prevDir
+	a.prevDir = dir
+	switch r.Rule {
+	case StepAIMD:
+		if flipped {
+			a.mult *= r.Shrink
+		} else if sustained {
+			a.mult += r.Increase
+		}
+	case StepRprop:
+		if flipped {
+			a.mult *= r.Shrink
+		} else if sustained {
+			a.mult *= r.Grow
+		}
+	case StepMomentum:
+		a.velocity = r.Beta*a.velocity + float64(dir)
+		a.mult = a.velocity * float64(dir)
+	default:
+		return
+	}
+	if a.mult < r.MinMult {
+		a.mult = r.MinMult
+	}
+	if a.mult > r.MaxMult {
+		a.mult = r.MaxMult
+	}
+}
+
+// step 回路朝 dir 方向调整时实际使用的步长 = base * 倍率，记下供出块统计；fixed 时即 base
+func (a *stepAdapter) step(dir int, base *big.Int) *big.Int {
+	a.update(dir)
+	step := base
+	if a.rule.Rule != StepFixed {
+		f := new(big.Float).Mul(new(big.Float).SetInt(base), big.NewFloat(a.mult))
+		step, _ = f.Int(nil)
+	}
+	a.applied = step
+	return step
+}
+
+// stepAdapterState 随 checkpoint 保存的倍率状态
+type stepAdapterState struct {
+	Mult     float64
+	PrevDir  int
+	Velocity float64
+}
+
+func (a *stepAdapter) state() stepAdapterState {
+	return stepAdapterState{Mult: a.mult, PrevDir: a.prevDir, Velocity: a.velocity}
+}
+
+func (a *stepAdapter) setState(st stepAdapterState) {
+	a.mult, a.prevDir, a.velocity = st.Mult, st.PrevDir, st.Velocity
+}
+
+// StepStats 按步长调整的算法（v3.x）本块实际使用的步长 (wei) 和调整后的倍率，回路本块没有调整时步长为空，其他算法全为空。
+// 时延回路的步长为 Subsidy 的调整量，Tax 调整其 n-1 倍
+type StepStats struct {
+	DelayStep   string `json:"delayStep"`
+	BalanceStep string `json:"balanceStep"`
+	DelayMult   string `json:"delayMult"`
+	BalanceMult string `json:"balanceMult"`
+}
+
+// StepReporter 按步长调整的算法实现此接口，出块后取出本块的步长统计
+type StepReporter interface {
+	TakeStepStats() StepStats
+}
+
+// stepHeader 与 StepStats.CSVCells 对应
+func stepHeader() []string {
+	return []string{"Delay Step", "Balance Step", "Delay Step Mult", "Balance Step Mult"}
+}
+
+func (ss StepStats) CSVCells() []string {
+	return []string{ss.DelayStep, ss.BalanceStep, ss.DelayMult, ss.BalanceMult}
+}
+
+// orDash 日志中回路本块没有调整时写 -
+func orDash(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SweepSpec 参数扫描：在 base 配置上对 grid 中各参数取值做笛卡尔积，每个组合是一次独立运行。
+// grid 的 key 为 Config 的 JSON 字段名，可用 . 指定嵌套字段（如 policyParams.a、source.repeat）
+type SweepSpec struct {
+	Base json.RawMessage              `json:"base"`
+	Grid map[string][]json.RawMessage `json:"grid"`
+}
+
+// SweepRun 一个参数组合
+type SweepRun struct {
+	Label      string
+	Values     map[string]string // grid key -> 取值的 JSON 文本
+	Cfg        *Config
+	Checkpoint string // 不为空时从该 checkpoint 继续运行（taxsim fork），各次运行各自读入，互不共享状态
+}
+
+// SweepResult 一次运行的概要结果，写入 index.csv
+type SweepResult struct {
+	Run          SweepRun
+	Blocks       int
+	Txs          int
+	ITXMeanMs    float64 // 各块 ITX 确认时延均值按笔数加权
+	CTXMeanMs    float64
+	ITXP95MaxMs  int64 // 各块 ITX p95 的最大值
+	CTXP95MaxMs  int64
+	MeanAbsDiff  string
+	FinalBalance string // 各分片最后一个区块的 Balance 之和
+	Elapsed      time.Duration
+	Err          error
+}
+
+func runSweep(args []string) {
+	fs := flag.NewFlagSet("taxsim sweep", flag.ExitOnError)
+	specPath := fs.String("grid", "", "参数扫描配置 JSON 路径（必填）")
+	workers := fs.Int("workers", runtime.NumCPU(), "并行运行数")
+	outDir := fs.String("out", filepath.Join("sweeps", runTimestamp()), "扫描输出目录，每次运行写到其下的子目录")
+	fs.Parse(args)
+	if *specPath == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	runs, err := LoadSweep(*specPath, *outDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "参数扫描配置错误: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("参数扫描：共 %d 个组合，%d 个并行，输出到 %s\n", len(runs), *workers, *outDir)
+
+	results := RunSweep(runs, *workers)
+	indexPath := filepath.Join(*outDir, "index.csv")
+	if err := writeSweepIndex(indexPath, runs, results); err != nil {
+		fmt.Fprintf(os.Stderr, "写出 %s 失败: %v\n", indexPath, err)
+		os.Exit(1)
+	}
+	fmt.Printf("参数扫描完成，结果汇总见 %s\n", indexPath)
+	for _, r := range results {
+		if r.Err != nil {
+			os.Exit(1)
+		}
+	}
+}
+
+// LoadSweep 读入扫描配置并展开为各次运行，每次运行的输出和日志都放在 outDir/{label} 下
+func LoadSweep(path, outDir string) ([]SweepRun, error) {
+	return loadSweepOn(path, outDir, DefaultConfig)
+}
+
+// loadSweepOn 同 LoadSweep，base 和各参数组合叠加在 newBase 给出的配置上
+func loadSweepOn(path, outDir string, newBase func() *Config) ([]SweepRun, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec SweepSpec
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %v", path, err)
+	}
+	if len(spec.Grid) == 0 {
+		return nil, errors.New("grid 不能为空")
+	}
+
+	// base 先叠加到默认配置上，再转成通用 map 便于按 key 覆盖
+	base := newBase()
+	if len(spec.Base) > 0 {
+		if err := decodeConfig(spec.Base, base); err != nil {
+			return nil, fmt.Errorf("base: %v", err)
+		}
+	}
+	baseJSON, err := json.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(spec.Grid))
+	for k, values := range spec.Grid {
+		if len(values) == 0 {
+			return nil, fmt.Errorf("grid.%s 没有取值", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var runs []SweepRun
+	var errs []error
+	idx := make([]int, len(keys)) // 各 key 当前取值的下标，按里程表方式进位
+	for {
+		v, err := decodeJSON(baseJSON)
+		if err != nil {
+			return nil, err
+		}
+		tree := v.(map[string]any)
+		values := make(map[string]string, len(keys))
+		parts := []string{fmt.Sprintf("run%03d", len(runs))}
+		for i, k := range keys {
+			raw := spec.Grid[k][idx[i]]
+			v, err := decodeJSON(raw)
+			if err != nil {
+				return nil, fmt.Errorf("grid.%s: %v", k, err)
+			}
+			setPath(tree, k, v)
+			values[k] = string(raw)
+			parts = append(parts, k+"="+strings.Trim(string(raw), `"`))
+		}
+		label := sanitizeLabel(strings.Join(parts, "_"))
+
+		cfg := newBase()
+		merged, _ := json.Marshal(tree)
+		if err := decodeConfig(merged, cfg); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", label, err))
+		} else {
+			runDir := filepath.Join(outDir, label)
+			cfg.OutputDir = runDir
+			cfg.LogPath = filepath.Join(runDir, "exp.log")
+			// 各次运行的 sink 和 checkpoint 一律写到自己的目录下，避免互相覆盖
+			for i := range cfg.Sinks {
+				cfg.Sinks[i].Path = ""
+			}
+			if cfg.Checkpoint.Path != "" {
+				cfg.Checkpoint.Path = filepath.Join(runDir, filepath.Base(cfg.Checkpoint.Path))
+			}
+			if err := cfg.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", label, err))
+			}
+			runs = append(runs, SweepRun{Label: label, Values: values, Cfg: cfg})
+		}
+
+		i := len(keys) - 1
+		for ; i >= 0; i-- {
+			idx[i]++
+			if idx[i] < len(spec.Grid[keys[i]]) {
+				break
+			}
+			idx[i] = 0
+		}
+		if i < 0 {
+			break
+		}
+	}
+	return runs, errors.Join(errs...)
+}
+
+// decodeJSON 把 JSON 解成通用结构，数字保留原文，避免大整数经 float64 丢精度
+func decodeJSON(data []byte) (any, error) {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	err := dec.Decode(&v)
+	return v, err
+}
+
+func decodeConfig(data []byte, cfg *Config) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(cfg)
+}
+
+// setPath 按 a.b.c 形式的路径设置嵌套 map 中的值，中间层不存在时创建
+func setPath(tree map[string]any, path string, v any) {
+	keys := strings.Split(path, ".")
+	for _, k := range keys[:len(keys)-1] {
+		sub, ok := tree[k].(map[string]any)
+		if !ok {
+			sub = map[string]any{}
+			tree[k] = sub
+		}
+		tree = sub
+	}
+	tree[keys[len(keys)-1]] = v
+}
+
+// sanitizeLabel 把 label 中不适合做目录名的字符换成 -
+func sanitizeLabel(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|', ' ', '{', '}', '[', ']', ',':
+			return '-'
+		}
+		return r
+	}, s)
+}
+
+// RunSweep 用 workers 个协程并行执行各次运行，结果与 runs 一一对应
+func RunSweep(runs []SweepRun, workers int) []SweepResult {
+	if workers < 1 {
+		workers = 1
+	}
+	results := make([]SweepResult, len(runs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var printLock sync.Mutex
+	done := 0
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = runOne(runs[i])
+				printLock.Lock()
+				done++
+				status := "完成"
+				if results[i].Err != nil {
+					status = fmt.Sprintf("失败: %v", results[i].Err)
+				}
+				fmt.Printf("[%d/%d] %s %s，耗时 %.1f 秒\n", done, len(runs), runs[i].Label, status, results[i].Elapsed.Seconds())
+				printLock.Unlock()
+			}
+		}()
+	}
+	for i := range runs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// runOne 执行一次运行，并由内存 sink 中的出块统计汇总概要结果
+func runOne(run SweepRun) SweepResult {
+	begin := time.Now()
+	res := SweepResult{Run: run}
+	if err := os.MkdirAll(run.Cfg.OutputDir, os.ModePerm); err != nil {
+		res.Err = err
+		return res
+	}
+	if err := os.WriteFile(filepath.Join(run.Cfg.OutputDir, "config.json"), []byte(run.Cfg.String()), 0644); err != nil {
+		res.Err = err
+		return res
+	}
+
+	mem := NewMemorySink()
+	sim := NewSim(run.Cfg, mem)
+	if run.Checkpoint != "" {
+		ck, err := LoadCheckpoint(run.Checkpoint)
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		sim.ResumeFrom(ck)
+	}
+	res.Err = sim.Run()
+	res.Elapsed = time.Since(begin)
+	summarize(&res, mem.Stats())
+	return res
+}
+
+func summarize(res *SweepResult, stats []BlockStats) {
+	var itxSum, ctxSum float64 // ms
+	var itxN, ctxN int
+	absDiffSum := new(big.Int)
+	lastBalance := map[uint64]string{}
+	for _, st := range stats {
+		res.Blocks++
+		res.Txs += st.TxCount
+		itxSum += float64(st.ITXLatency.Mean.Milliseconds()) * float64(st.ITXLatency.Count)
+		itxN += st.ITXLatency.Count
+		ctxSum += float64(st.CTXLatency.Mean.Milliseconds()) * float64(st.CTXLatency.Count)
+		ctxN += st.CTXLatency.Count
+		if ms := st.ITXLatency.P95.Milliseconds(); ms > res.ITXP95MaxMs {
+			res.ITXP95MaxMs = ms
+		}
+		if ms := st.CTXLatency.P95.Milliseconds(); ms > res.CTXP95MaxMs {
+			res.CTXP95MaxMs = ms
+		}
+		if d, ok := new(big.Int).SetString(st.Diff, 10); ok {
+			absDiffSum.Add(absDiffSum, d.Abs(d))
+		}
+		lastBalance[st.ShardID] = st.Balance
+	}
+	if itxN > 0 {
+		res.ITXMeanMs = itxSum / float64(itxN)
+	}
+	if ctxN > 0 {
+		res.CTXMeanMs = ctxSum / float64(ctxN)
+	}
+	if res.Blocks > 0 {
+		res.MeanAbsDiff = absDiffSum.Div(absDiffSum, big.NewInt(int64(res.Blocks))).String()
+	}
+	total := new(big.Int)
+	for _, b := range lastBalance {
+		if v, ok := new(big.Int).SetString(b, 10); ok {
+			total.Add(total, v)
+		}
+	}
+	res.FinalBalance = total.String()
+}
+
+// writeSweepIndex 每次运行一行：label、输出目录、各 grid 参数取值和概要结果
+func writeSweepIndex(path string, runs []SweepRun, results []SweepResult) error {
+	var keys []string
+	if len(runs) > 0 {
+		for k := range runs[0].Values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
+	file, err := createFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := csv.NewWriter(file)
+
+	header := append([]string{"Run", "Dir"}, keys...)
+	header = append(header, "Blocks", "Txs", "ITX Latency Mean(ms)", "CTX Latency Mean(ms)",
+		"ITX Latency P95 Max(ms)", "CTX Latency P95 Max(ms)", "Mean |Diff|", "Final Balance", "Elapsed(s)", "Error")
+	w.Write(header)
+	for _, r := range results {
+		row := []string{r.Run.Label, r.Run.Cfg.OutputDir}
+		for _, k := range keys {
+			row = append(row, strings.Trim(r.Run.Values[k], `"`))
+		}
+		errStr := ""
+		if r.Err != nil {
+			errStr = r.Err.Error()
+		}
+		row = append(row,
+			fmt.Sprint(r.Blocks),
+			fmt.Sprint(r.Txs),
+			fmt.Sprintf("%.1f", r.ITXMeanMs),
+			fmt.Sprintf("%.1f", r.CTXMeanMs),
+			fmt.Sprint(r.ITXP95MaxMs),
+			fmt.Sprint(r.CTXP95MaxMs),
+			r.MeanAbsDiff,
+			r.FinalBalance,
+			fmt.Sprintf("%.1f", r.Elapsed.Seconds()),
+			errStr,
+		)
+		w.Write(row)
+	}
+	w.Flush()
+	return w.Error()
+}
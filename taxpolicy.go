@@ -0,0 +1,500 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// TaxPolicy 税池调节算法：根据刚打包的区块和税池状态，给出下一高度区块使用的 Tax/Subsidy。
+// 调用 Next 前 tp 已由 UpdateDiffAndBalance 按 txs 更新了 Diff、Balance 等统计量
+type TaxPolicy interface {
+	Name() string
+	Next(tp *TaxPool, txs []*Transaction) (tax, subsidy *big.Int)
+}
+
+// PolicyParams 算法参数，key 为参数名
+type PolicyParams map[string]float64
+
+// Float 取参数，未配置时返回 def
+func (p PolicyParams) Float(key string, def float64) float64 {
+	if v, ok := p[key]; ok {
+		return v
+	}
+	return def
+}
+
+// BigInt 取整数参数（如 delta、epsilon），未配置时返回 def
+func (p PolicyParams) BigInt(key string, def int64) *big.Int {
+	v, ok := p[key]
+	if !ok {
+		return big.NewInt(def)
+	}
+	n, _ := new(big.Float).SetFloat64(v).Int(nil)
+	return n
+}
+
+// check 检查是否有算法不认识的参数，防止参数名拼错被静默忽略
+func (p PolicyParams) check(allowed ...string) error {
+	ok := make(map[string]bool, len(allowed))
+	for _, k := range allowed {
+		ok[k] = true
+	}
+	for k := range p {
+		if !ok[k] {
+			return fmt.Errorf("未知参数 %q，可用参数: %s", k, strings.Join(allowed, ", "))
+		}
+	}
+	return nil
+}
+
+// TaxPolicyFactory 由参数构造一个算法实例，每个分片各持有一个实例
+type TaxPolicyFactory func(params PolicyParams, cfg *Config) (TaxPolicy, error)
+
+var taxPolicies = map[string]TaxPolicyFactory{}
+
+// RegisterTaxPolicy 按名字注册税池调节算法，新算法在 init 中注册即可被配置选用
+func RegisterTaxPolicy(name string, factory TaxPolicyFactory) {
+	if _, dup := taxPolicies[name]; dup {
+		panic("重复注册 TaxPolicy: " + name)
+	}
+	taxPolicies[name] = factory
+}
+
+// NewTaxPolicy 按名字和参数构造算法实例
+func NewTaxPolicy(name string, params PolicyParams, cfg *Config) (TaxPolicy, error) {
+	factory, ok := taxPolicies[name]
+	if !ok {
+		return nil, fmt.Errorf("未知的 policy: %q，可用: %s", name, strings.Join(TaxPolicyNames(), ", "))
+	}
+	policy, err := factory(params, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("policy %s: %v", name, err)
+	}
+	return policy, nil
+}
+
+// TaxPolicyNames 已注册的算法名，按字典序
+func TaxPolicyNames() []string {
+	names := make([]string, 0, len(taxPolicies))
+	for name := range taxPolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterTaxPolicy("v1", newPolicyV1)
+	RegisterTaxPolicy("v2", newPolicyV2)
+	RegisterTaxPolicy("v3", newPolicyV3)
+	RegisterTaxPolicy("v3.2", newPolicyV3_2)
+	RegisterTaxPolicy("v3.3", newPolicyV3_3)
+	RegisterTaxPolicy("v3.4", newPolicyV3_4)
+}
+
+// stepParams v3.x 共用的步长和容忍区间，默认取运行配置中的 Delta 和各 epsilon；
+// 两个回路的步长再按运行配置的 stepRule 自适应缩放
+type stepParams struct {
+	shardNum            int
+	delta               *big.Int
+	epsilonDelay        *big.Int
+	epsilonBalance      *big.Int
+	epsilonDeltaBalance *big.Int
+	minFactor           *big.Float
+	maxFactor           *big.Float
+	delayStep           *stepAdapter
+	balanceStep         *stepAdapter
+}
+
+var stepParamNames = []string{"delta", "epsilonDelay", "epsilonBalance", "epsilonDeltaBalance", "minFactor", "maxFactor"}
+
+func newStepParams(params PolicyParams, cfg *Config, minFactor, maxFactor float64) (stepParams, error) {
+	sp := stepParams{
+		shardNum:            cfg.ShardNum,
+		delta:               params.BigInt("delta", cfg.Delta),
+		epsilonDelay:        params.BigInt("epsilonDelay", cfg.EpsilonDelay),
+		epsilonBalance:      params.BigInt("epsilonBalance", cfg.EpsilonBalance),
+		epsilonDeltaBalance: params.BigInt("epsilonDeltaBalance", cfg.EpsilonDeltaBalance),
+		minFactor:           big.NewFloat(params.Float("minFactor", minFactor)),
+		maxFactor:           big.NewFloat(params.Float("maxFactor", maxFactor)),
+		delayStep:           newStepAdapter(cfg.StepRule),
+		balanceStep:         newStepAdapter(cfg.StepRule),
+	}
+	if sp.delta.Sign() < 0 || sp.epsilonDelay.Sign() < 0 || sp.epsilonBalance.Sign() < 0 || sp.epsilonDeltaBalance.Sign() < 0 {
+		return sp, fmt.Errorf("delta 和各 epsilon 不能为负")
+	}
+	if sp.minFactor.Sign() <= 0 || sp.minFactor.Cmp(sp.maxFactor) > 0 {
+		return sp, fmt.Errorf("factor 区间不合法: [%s, %s]", sp.minFactor, sp.maxFactor)
+	}
+	return sp, nil
+}
+
+// scaledDelta 计算 factor * delta，因可能是小数，所以先用 big.Float 计算再转回 big.Int
+func (sp stepParams) scaledDelta(deviation, epsilon *big.Int) *big.Int {
+	factor := GetFactorInRange(deviation, epsilon, sp.minFactor, sp.maxFactor)
+	effectiveDelta := new(big.Float).Mul(new(big.Float).SetInt(sp.delta), factor)
+	effectiveDeltaInt := new(big.Int)
+	effectiveDelta.Int(effectiveDeltaInt)
+	return effectiveDeltaInt
+}
+
+// adjustForDelay 按时延偏离调整：ctx 时延高时 Tax + Δ*(n-1), Subsidy + Δ；itx 时延高时反向
+func (sp stepParams) adjustForDelay(tax, subsidy, diffWithSign, step *big.Int) {
+	dir := -1
+	if diffWithSign.Sign() > 0 {
+		dir = 1
+	}
+	step = sp.delayStep.step(dir, step)
+	taxStep := new(big.Int).Mul(step, big.NewInt(int64(sp.shardNum-1)))
+	if dir > 0 {
+		tax.Add(tax, taxStep)
+		subsidy.Add(subsidy, step)
+	} else {
+		tax.Sub(tax, taxStep)
+		subsidy.Sub(subsidy, step)
+	}
+}
+
+// adjustForBalance 按税池平衡调整：raise 时 Tax + Δ, Subsidy - Δ，否则反向
+func (sp stepParams) adjustForBalance(tax, subsidy *big.Int, raise bool, step *big.Int) {
+	dir := -1
+	if raise {
+		dir = 1
+	}
+	step = sp.balanceStep.step(dir, step)
+	if raise {
+		tax.Add(tax, step)
+		subsidy.Sub(subsidy, step)
+	} else {
+		tax.Sub(tax, step)
+		subsidy.Add(subsidy, step)
+	}
+}
+
+// TakeStepStats 取出本块两个回路实际使用的步长
+func (sp stepParams) TakeStepStats() StepStats {
+	ss := StepStats{
+		DelayStep:   bigStr(sp.delayStep.applied),
+		BalanceStep: bigStr(sp.balanceStep.applied),
+		DelayMult:   fmt.Sprintf("%.4f", sp.delayStep.mult),
+		BalanceMult: fmt.Sprintf("%.4f", sp.balanceStep.mult),
+	}
+	sp.delayStep.applied, sp.balanceStep.applied = nil, nil
+	return ss
+}
+
+// SaveState 两个回路的步长倍率
+func (sp stepParams) SaveState() ([]byte, error) {
+	return encodeGob([2]stepAdapterState{sp.delayStep.state(), sp.balanceStep.state()})
+}
+
+func (sp stepParams) LoadState(data []byte) error {
+	var st [2]stepAdapterState
+	if err := decodeGob(data, &st); err != nil {
+		return err
+	}
+	sp.delayStep.setState(st[0])
+	sp.balanceStep.setState(st[1])
+	return nil
+}
+
+func inBand(v, epsilon *big.Int) bool {
+	return v.Cmp(epsilon) <= 0 && v.Cmp(new(big.Int).Neg(epsilon)) >= 0
+}
+
+// ---------------- v1 ----------------
+
+// policyV1 Tax/Subsidy 直接取 Diff 的 a、b 倍，按 DeltaBalance 正负决定谁多谁少
+type policyV1 struct {
+	a, b float64
+}
+
+func newPolicyV1(params PolicyParams, cfg *Config) (TaxPolicy, error) {
+	if err := params.check("a", "b"); err != nil {
+		return nil, err
+	}
+	for _, k := range []string{"a", "b"} {
+		if _, ok := params[k]; !ok {
+			return nil, fmt.Errorf("需要参数 %s", k)
+		}
+	}
+	return &policyV1{a: params["a"], b: params["b"]}, nil
+}
+
+func (p *policyV1) Name() string { return "v1" }
+
+func (p *policyV1) Next(tp *TaxPool, txs []*Transaction) (*big.Int, *big.Int) {
+	scale := func(k float64) *big.Int {
+		DiffFloat := new(big.Float).SetInt(tp.Diff) // 把 *big.Int 转为 *big.Float
+		DiffFloat.Mul(DiffFloat, big.NewFloat(k))
+		v, _ := DiffFloat.Int(nil) // 转回整数，小数部分会被截断
+		return v
+	}
+	if tp.DeltaBalance.Sign() <= 0 { // 补贴大于税收时，补贴发少一点(*b)，税收多一点(*a)
+		return scale(p.a), scale(p.b)
+	}
+	return scale(p.b), scale(p.a)
+}
+
+// ---------------- v2 ----------------
+
+// policyV2 由最低收益 P_itx_min、P_ctx_min 和 itx/ctx 数目直接解出 s 和 t，
+// 最低手续费取手续费信号（默认即块内最低值，见 SignalConfig）
+type policyV2 struct{}
+
+func newPolicyV2(params PolicyParams, cfg *Config) (TaxPolicy, error) {
+	if err := params.check(); err != nil {
+		return nil, err
+	}
+	return &policyV2{}, nil
+}
+
+func (p *policyV2) Name() string { return "v2" }
+
+func (p *policyV2) Next(tp *TaxPool, txs []*Transaction) (*big.Int, *big.Int) {
+	tax, subsidy := new(big.Int).Set(tp.Tax), new(big.Int).Set(tp.Subsidy)
+	// 区块中缺 itx 或 ctx 时没有最低收益可比，不调整
+	if tp.Signal_ctx == nil || tp.Signal_itx == nil {
+		return tax, subsidy
+	}
+
+	// 正确计算 P_ctx_min
+	halfFctx := new(big.Int).Div(tp.Signal_ctx, big.NewInt(2))
+	tp.P_ctx_min = new(big.Int).Add(halfFctx, tp.Subsidy)
+
+	// 正确计算 P_itx_min
+	tp.P_itx_min = new(big.Int).Sub(tp.Signal_itx, tp.Tax)
+
+	// 开始推导 s 和 t：
+	if tp.TotalTaxNum.Sign() > 0 && tp.TotalSubsidyNum.Sign() > 0 {
+		p_ctx := new(big.Float).SetInt(tp.P_ctx_min)
+		p_itx := new(big.Float).SetInt(tp.P_itx_min)
+
+		nItxFloat := new(big.Float).SetInt(tp.TotalTaxNum)
+		nCtxFloat := new(big.Float).SetInt(tp.TotalSubsidyNum)
+
+		// numerator = 2 * P_ctx - P_itx
+		num := new(big.Float).Mul(big.NewFloat(2), p_ctx)
+		num.Sub(num, p_itx)
+
+		// s = numerator / (2 + n_ctx/n_itx)
+		denS := new(big.Float).Quo(nCtxFloat, nItxFloat)
+		denS.Add(denS, big.NewFloat(2))
+		sFloat := new(big.Float).Quo(num, denS)
+
+		// t = numerator / (2n_itx/n_ctx + 1)
+		denT := new(big.Float).Quo(nItxFloat, nCtxFloat)
+		denT.Mul(denT, big.NewFloat(2))
+		denT.Add(denT, big.NewFloat(1))
+		tFloat := new(big.Float).Quo(num, denT)
+
+		// 转回 big.Int，截断小数
+		sFloat.Int(subsidy)
+		tFloat.Int(tax)
+	}
+	return tax, subsidy
+}
+
+// ---------------- v3 ----------------
+
+// policyV3 固定步长 Δ：先调时延平衡（Diff_withsign），时延平衡后再调税池平衡（DeltaBalance）
+type policyV3 struct {
+	stepParams
+}
+
+func newPolicyV3(params PolicyParams, cfg *Config) (TaxPolicy, error) {
+	if err := params.check("delta", "epsilonDelay", "epsilonBalance"); err != nil {
+		return nil, err
+	}
+	sp, err := newStepParams(params, cfg, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &policyV3{sp}, nil
+}
+
+func (p *policyV3) Name() string { return "v3" }
+
+func (p *policyV3) Next(tp *TaxPool, txs []*Transaction) (*big.Int, *big.Int) {
+	tax, subsidy := new(big.Int).Set(tp.Tax), new(big.Int).Set(tp.Subsidy)
+
+	// 检查是否满足平衡条件
+	// ε_d 和 ε_b 是判断“是否近似为0”的上下限（可配置）
+	// 判断时延平衡是否在 [-ε, ε] 区间内
+	delayBalanced := inBand(tp.Diff_withsign, p.epsilonDelay)
+
+	// 判断税池平衡是否在 [-ε', ε'] 区间内
+	taxpoolBalanced := inBand(tp.DeltaBalance, p.epsilonBalance)
+
+	// 时延平衡，税池平衡，不做调整
+	if delayBalanced && taxpoolBalanced {
+		return tax, subsidy
+	}
+
+	// 时延不平衡，税池平衡 + 都不平衡，优先处理时延
+	if !delayBalanced {
+		p.adjustForDelay(tax, subsidy, tp.Diff_withsign, p.delta)
+		return tax, subsidy
+	}
+
+	// 时延平衡，税池不平衡
+	// taxpool 增长：Tax - Δ、subsidy +Δ；taxpool 减少：Tax +Δ 、subsidy -Δ
+	p.adjustForBalance(tax, subsidy, tp.DeltaBalance.Sign() <= 0, p.delta)
+	return tax, subsidy
+}
+
+// ---------------- v3.2 ----------------
+
+// policyV3_2 步长按偏离/容忍区间放大（factor 取 [0.1, 8]），税池平衡改用 Balance 判断
+type policyV3_2 struct {
+	stepParams
+}
+
+func newPolicyV3_2(params PolicyParams, cfg *Config) (TaxPolicy, error) {
+	if err := params.check("delta", "epsilonDelay", "epsilonBalance", "minFactor", "maxFactor"); err != nil {
+		return nil, err
+	}
+	sp, err := newStepParams(params, cfg, 0.1, 8.0)
+	if err != nil {
+		return nil, err
+	}
+	return &policyV3_2{sp}, nil
+}
+
+func (p *policyV3_2) Name() string { return "v3.2" }
+
+func (p *policyV3_2) Next(tp *TaxPool, txs []*Transaction) (*big.Int, *big.Int) {
+	tax, subsidy := new(big.Int).Set(tp.Tax), new(big.Int).Set(tp.Subsidy)
+
+	// 判断是否平衡，税池平衡用 balance 判断
+	delayBalanced := inBand(tp.Diff_withsign, p.epsilonDelay)
+	taxpoolBalanced := inBand(tp.Balance, p.epsilonBalance)
+
+	// 平衡就不调整
+	if delayBalanced && taxpoolBalanced {
+		return tax, subsidy
+	}
+
+	if !delayBalanced {
+		// 时延偏离因子
+		p.adjustForDelay(tax, subsidy, tp.Diff_withsign, p.scaledDelta(tp.Diff_withsign, p.epsilonDelay))
+		return tax, subsidy
+	}
+
+	// 税池偏离因子
+	// 税池增长：Tax - Δ、Subsidy + Δ；税池减少：Tax + Δ、Subsidy - Δ
+	p.adjustForBalance(tax, subsidy, tp.Balance.Sign() <= 0, p.scaledDelta(tp.Balance, p.epsilonBalance))
+	return tax, subsidy
+}
+
+// ---------------- v3.3 ----------------
+
+// policyV3_3 同 v3.2，factor 下界为 1，时延平衡和税池平衡调整步长统一
+type policyV3_3 struct {
+	stepParams
+}
+
+func newPolicyV3_3(params PolicyParams, cfg *Config) (TaxPolicy, error) {
+	if err := params.check("delta", "epsilonDelay", "epsilonBalance", "minFactor", "maxFactor"); err != nil {
+		return nil, err
+	}
+	sp, err := newStepParams(params, cfg, 1, 8.0)
+	if err != nil {
+		return nil, err
+	}
+	return &policyV3_3{sp}, nil
+}
+
+func (p *policyV3_3) Name() string { return "v3.3" }
+
+func (p *policyV3_3) Next(tp *TaxPool, txs []*Transaction) (*big.Int, *big.Int) {
+	tax, subsidy := new(big.Int).Set(tp.Tax), new(big.Int).Set(tp.Subsidy)
+
+	delayBalanced := inBand(tp.Diff_withsign, p.epsilonDelay)
+	taxpoolBalanced := inBand(tp.Balance, p.epsilonBalance)
+
+	// 时延平衡 && 税池平衡，不调整税收或者补贴返回
+	if delayBalanced && taxpoolBalanced {
+		return tax, subsidy
+	}
+
+	// 优先调整时延平衡
+	if !delayBalanced {
+		// ctx 时延高，ctx 竞争不过itx，加税：Tax + factor_delay * delta * (n-1), Subsidy + factor * delta
+		// itx 时延高，itx竞争不过ctx，减税：Tax - factor_delay * delta * (n-1), Subsidy - factor * delta
+		p.adjustForDelay(tax, subsidy, tp.Diff_withsign, p.scaledDelta(tp.Diff_withsign, p.epsilonDelay))
+		return tax, subsidy
+	}
+
+	// 税池偏离因子
+	// 税池增长，税收多了：Tax - factor_balance * delta、Subsidy + factor_balance * delta
+	// 税池减少，税收少了：Tax + factor_balance * delta、Subsidy - factor_balance * delta
+	p.adjustForBalance(tax, subsidy, tp.Balance.Sign() <= 0, p.scaledDelta(tp.Balance, p.epsilonBalance))
+	return tax, subsidy
+}
+
+// ---------------- v3.4 ----------------
+
+// policyV3_4 时延平衡后，按 Balance 和 DeltaBalance 所在区域（蓝/黄/红）决定加税、不变或减税
+type policyV3_4 struct {
+	stepParams
+}
+
+func newPolicyV3_4(params PolicyParams, cfg *Config) (TaxPolicy, error) {
+	if err := params.check(stepParamNames...); err != nil {
+		return nil, err
+	}
+	sp, err := newStepParams(params, cfg, 1, 8.0)
+	if err != nil {
+		return nil, err
+	}
+	return &policyV3_4{sp}, nil
+}
+
+func (p *policyV3_4) Name() string { return "v3.4" }
+
+func (p *policyV3_4) Next(tp *TaxPool, txs []*Transaction) (*big.Int, *big.Int) {
+	tax, subsidy := new(big.Int).Set(tp.Tax), new(big.Int).Set(tp.Subsidy)
+
+	// 优先调整时延平衡
+	if !inBand(tp.Diff_withsign, p.epsilonDelay) {
+		p.adjustForDelay(tax, subsidy, tp.Diff_withsign, p.scaledDelta(tp.Diff_withsign, p.epsilonDelay))
+		return tax, subsidy
+	}
+
+	// 先计算 factor_balance&deltabalance避免重复计算
+	balancePlusDeltabalance := new(big.Int).Add(tp.Balance, tp.DeltaBalance)
+	epsilonSum := new(big.Int).Add(p.epsilonBalance, p.epsilonDeltaBalance)
+	step := p.scaledDelta(balancePlusDeltabalance, epsilonSum)
+
+	raiseTax := func() { // 表格蓝色区域：+tax -subsidy
+		p.adjustForBalance(tax, subsidy, true, step)
+	}
+	cutTax := func() { // 表格红色区域：-tax +subsidy
+		p.adjustForBalance(tax, subsidy, false, step)
+	}
+
+	// 然后再调税池平衡，表格黄色区域：tax, subsidy 不变
+	if tp.Balance.Sign() <= 0 { // balance < 0
+		switch {
+		case tp.DeltaBalance.Sign() <= 0:
+			raiseTax()
+		case tp.Balance.Cmp(new(big.Int).Neg(p.epsilonBalance)) < 0:
+			raiseTax()
+		case tp.DeltaBalance.Cmp(p.epsilonDeltaBalance) > 0:
+			cutTax()
+		}
+	} else { // balance > 0
+		switch {
+		case tp.DeltaBalance.Sign() > 0:
+			cutTax()
+		case tp.Balance.Cmp(p.epsilonBalance) > 0:
+			cutTax()
+		case tp.DeltaBalance.Cmp(new(big.Int).Neg(p.epsilonDeltaBalance)) <= 0:
+			raiseTax()
+		}
+	}
+	return tax, subsidy
+}
@@ -22,6 +22,11 @@ type Transaction struct {
 	GasUsed   *big.Int
 	TxHash    []byte
 
+	// EIP-1559 费用模型（feeModel = eip1559）下的出价上限和上链时实际得到的每 gas 小费，legacy 模型下均为 nil
+	MaxFeePerGas         *big.Int
+	MaxPriorityFeePerGas *big.Int
+	EffectiveTip         *big.Int
+
 	Time time.Time // TimeStamp the tx proposed.
 
 	// 所属分片和区块编号
@@ -30,6 +35,16 @@ type Transaction struct {
 
 	//是否为跨分片交易
 	isCTX bool
+
+	// 跨分片交易的第二段：源分片打包 relay1 后，在目的分片上链的 relay2（Monoxide）；
+	// broker 模式下即 broker1 上链后由 broker 发出的 broker2（BrokerChain）
+	Relayed       bool
+	Relay1Time    time.Time // relay1 / broker1 在源分片上链时间
+	Relay1Subsidy *big.Int  // relay1 在源分片获得的补贴
+	Relay1Tax     *big.Int  // broker1 在源分片被收的税
+
+	// broker 模式下经手这笔跨分片交易的 broker，broker1、broker2 都是片内交易、各自按手续费被收税；非 broker 交易为空
+	Broker Address
 }
 
 // NewTransaction new a transaction
@@ -46,8 +61,7 @@ func NewTransaction(sender, recipient string, value, gasPrice, gasUsed *big.Int,
 	hash := sha256.Sum256(tx.Encode())
 	tx.TxHash = hash[:]
 
-	//根据 sender 和 receiver 所在分片判断是否为跨分片交易
-	tx.isCTX = isCtx(sender, recipient)
+	// 是否为跨分片交易取决于分片数，由注入交易池时调用 isCtx 判断
 
 	return tx
 }
@@ -90,9 +104,9 @@ func DecodeTx(to_decode []byte) *Transaction {
 	return &tx
 }
 
-func isCtx(sender Address, recipient Address) bool {
-	ssid := uint64(Addr2Shard(sender))
-	rsid := uint64(Addr2Shard(recipient))
+func isCtx(sender Address, recipient Address, shardNum int) bool {
+	ssid := uint64(Addr2Shard(sender, shardNum))
+	rsid := uint64(Addr2Shard(recipient, shardNum))
 	if ssid != rsid {
 		return true
 	} else {
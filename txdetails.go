@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TxDetail 一笔交易最终上链（片内交易所在区块 / 跨分片交易 relay2 所在区块）时的明细
+type TxDetail struct {
+	Sender           Address
+	Recipient        Address
+	ShardID          uint64 // 最终上链的分片
+	SenderShardID    uint64
+	RecipientShardID uint64
+	BlockNumber      uint64
+	Nonce            uint64   // 发送方在处理分片上的 nonce
+	BaseFee          *big.Int // EIP-1559 模型下最终上链区块的 base fee，legacy 为 nil
+	EffectiveTip     *big.Int // EIP-1559 模型下最终上链那一段矿工得到的每 gas 小费，legacy 为 nil
+	IsCTX            bool
+	GasPrice         *big.Int
+	GasUsed          *big.Int
+	Tax              *big.Int // ITX 被收的税，broker 交易为 broker1 + broker2 两段之和，relay 的 CTX 为 nil
+	Subsidy          *big.Int // relay 的 CTX 两段（relay1 + relay2）获得的补贴之和，其余为 nil
+	ProposeTime      time.Time
+	BlockTime        time.Time
+	CommitTime       time.Time
+	Relay1Time       time.Time // 非 relay 交易为零值
+	Relay2Time       time.Time
+	Broker1Time      time.Time // 非 broker 交易为零值
+	Broker2Time      time.Time
+	ConfirmLatency   time.Duration
+}
+
+// txDetailHeader 与 figurePlot 脚本一致：部分脚本按列下标读取
+// （3 提出时间、5 最终上链时间、6/7 Relay1/Relay2、8/9 Broker1/Broker2、11 Gas Price、12 Gas Used），新增列只能追加在末尾
+var txDetailHeader = []string{
+	"Sender", "Recipient", "Shard ID",
+	"Tx propose timestamp", "Block propose timestamp", "Tx finally commit timestamp",
+	"Relay1 Tx commit timestamp (not a relay tx -> nil)",
+	"Relay2 Tx commit timestamp (not a relay tx -> nil)",
+	"Broker1 Tx commit timestamp (not a broker tx -> nil)",
+	"Broker2 Tx commit timestamp (not a broker tx -> nil)",
+	"Confirmed latency of this tx (ms)",
+	"Gas Price", "Gas Used",
+	"Block Number", "Sender Shard ID", "Recipient Shard ID", "IsCTX", "Tax", "Subsidy", "Nonce",
+	"Base Fee", "Effective Tip",
+}
+
+// msStr 模拟时钟时间戳（ms），零值写空串，pandas 读入后为 NaN
+func msStr(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return fmt.Sprint(t.UnixMilli())
+}
+
+func bigStr(v *big.Int) string {
+	if v == nil {
+		return ""
+	}
+	return v.String()
+}
+
+func (d TxDetail) Row() []string {
+	return []string{
+		d.Sender,
+		d.Recipient,
+		fmt.Sprint(d.ShardID),
+		msStr(d.ProposeTime),
+		msStr(d.BlockTime),
+		msStr(d.CommitTime),
+		msStr(d.Relay1Time),
+		msStr(d.Relay2Time),
+		msStr(d.Broker1Time),
+		msStr(d.Broker2Time),
+		fmt.Sprint(d.ConfirmLatency.Milliseconds()),
+		bigStr(d.GasPrice),
+		bigStr(d.GasUsed),
+		fmt.Sprint(d.BlockNumber),
+		fmt.Sprint(d.SenderShardID),
+		fmt.Sprint(d.RecipientShardID),
+		fmt.Sprint(d.IsCTX),
+		bigStr(d.Tax),
+		bigStr(d.Subsidy),
+		fmt.Sprint(d.Nonce),
+		bigStr(d.BaseFee),
+		bigStr(d.EffectiveTip),
+	}
+}
+
+// TxDetailWriter 把交易明细写成 Tx_Details.csv
+type TxDetailWriter struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+// NewTxDetailWriter appendRows 为 true 时追加到已有文件末尾（从 checkpoint 继续时），文件为空才写表头
+func NewTxDetailWriter(path string, appendRows bool) (*TxDetailWriter, error) {
+	var file *os.File
+	var err error
+	if appendRows {
+		if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err == nil {
+			file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		}
+	} else {
+		file, err = createFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("无法创建 %s: %v", path, err)
+	}
+	w := &TxDetailWriter{file: file, writer: csv.NewWriter(file)}
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		return w, nil
+	}
+	if err := w.writer.Write(txDetailHeader); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *TxDetailWriter) Write(details []TxDetail) error {
+	for _, d := range details {
+		if err := w.writer.Write(d.Row()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *TxDetailWriter) Close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
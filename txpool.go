@@ -1,34 +1,469 @@
 package main
 
 import (
+	"container/heap"
 	"math/big"
+	"math/rand"
 	"sort"
 	"sync"
 	"time"
 )
 
+// poolEntry 交易池中的一笔交易。key 为与税池无关的排序依据：ITX 为手续费，CTX 为手续费/2（EIP-1559 模型下为小费），
+// 实际收益 ITX = key - Tax，CTX = key + Subsidy，同类交易之间的先后不随 Tax/Subsidy 变化
+type poolEntry struct {
+	tx    *Transaction
+	key   *big.Int
+	base  *big.Int      // 不含 aging 加成的 key，即 txKey
+	seq   int64         // 进池顺序，收益相同时先进先出，保证打包结果可复现
+	added time.Time     // 进池时刻，替换交易为重发时刻，用于判断交易是否卡住
+	acc   *account      // 发送方账户，relay2 交易由系统发出、不受 nonce 约束，为 nil
+	from  uint64        // relay2 交易的源分片
+	idx   [numSlots]int // 在各个堆中的下标，不在堆中为 -1
+
+	starved      int64     // 连续挨饿的区块数，见 UpdateStarvation
+	starvedSince time.Time // 第一次被发现挨饿的时刻
+}
+
+// 一笔交易可同时位于三类堆中，各用 poolEntry.idx 的一个下标
+const (
+	slotCompete = iota // itxs/ctxs：账户队首交易参与打包竞争
+	slotFee            // itxFees/ctxFees：容量满时驱逐收益最低的交易
+	slotTime           // byTime：按进池时间过期
+	slotBase           // itxBase/ctxBase：按 base 找出可能挨饿的交易
+	numSlots
+)
+
+// account 一个发送方在本分片的交易队列。nonce 在 [next, end) 内连续的是可打包的 pending 交易，
+// 只有 nonce == next 的一笔在堆中参与竞争；nonce >= end 的交易前面有空缺，放在 queued 中等空缺补上
+type account struct {
+	next uint64                // 下一笔要打包的 nonce
+	end  uint64                // 从 next 起第一个缺失的 nonce
+	txs  map[uint64]*poolEntry // 尚未打包的交易，按 nonce
+}
+
+// entryHeap 记录下标的堆，可 O(log n) 删除任意交易，less 决定堆顶
+type entryHeap struct {
+	items []*poolEntry
+	slot  int
+	less  func(a, b *poolEntry) bool
+}
+
+func (h *entryHeap) Len() int           { return len(h.items) }
+func (h *entryHeap) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+func (h *entryHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.items[i].idx[h.slot] = i
+	h.items[j].idx[h.slot] = j
+}
+func (h *entryHeap) Push(x any) {
+	e := x.(*poolEntry)
+	e.idx[h.slot] = len(h.items)
+	h.items = append(h.items, e)
+}
+func (h *entryHeap) Pop() any {
+	n := len(h.items)
+	e := h.items[n-1]
+	h.items[n-1] = nil
+	h.items = h.items[:n-1]
+	e.idx[h.slot] = -1
+	return e
+}
+
+// remove 把 e 从堆中删除，e 不在堆中时什么也不做
+func (h *entryHeap) remove(e *poolEntry) {
+	if i := e.idx[h.slot]; i >= 0 {
+		heap.Remove(h, i)
+	}
+}
+
+// fix e 的 key 变化后调整它在堆中的位置，e 不在堆中时什么也不做
+func (h *entryHeap) fix(e *poolEntry) {
+	if i := e.idx[h.slot]; i >= 0 {
+		heap.Fix(h, i)
+	}
+}
+
+// 收益高的在前，相同则先进池的在前
+func higherKey(a, b *poolEntry) bool {
+	if c := a.key.Cmp(b.key); c != 0 {
+		return c > 0
+	}
+	return a.seq < b.seq
+}
+
+// 收益低的在前，相同则后进池的在前（先被驱逐）
+func lowerKey(a, b *poolEntry) bool {
+	return higherKey(b, a)
+}
+
+// base 低的在前，相同则先进池的在前
+func lowerBase(a, b *poolEntry) bool {
+	if c := a.base.Cmp(b.base); c != 0 {
+		return c < 0
+	}
+	return a.seq < b.seq
+}
+
+// 进池时间早的在前
+func earlierTime(a, b *poolEntry) bool {
+	if !a.tx.Time.Equal(b.tx.Time) {
+		return a.tx.Time.Before(b.tx.Time)
+	}
+	return a.seq < b.seq
+}
+
+// DropCounts 交易池因容量满被驱逐、因超过存活时间过期而丢弃的交易数，relay1 计入 CTX
+type DropCounts struct {
+	EvictedITX int
+	EvictedCTX int
+	ExpiredITX int
+	ExpiredCTX int
+}
+
+// TxPool 交易池：按发送方分账户、账户内按 nonce 排队，每个账户只有队首交易参与竞争。
+// 队首交易中 ITX 和 CTX（relay1 以及目的分片收到的 relay2）各放一个堆，
+// 因为 Tax 对所有 ITX、Subsidy 对所有 CTX 的收益影响相同，税池变化时两个堆都无需重排，
+// 打包时只需比较两个堆顶，代价为 O(blockSize log n)。
+// 设置了容量或存活时间时，另按收益升序、进池时间升序维护全部非 relay2 交易，用于驱逐和过期；
+// relay2 交易的第一段已经上链，不受容量和存活时间限制
 type TxPool struct {
-	TxQueue   []*Transaction            // transaction Queue
-	RelayPool map[uint64][]*Transaction //designed for sharded blockchain, from Monoxide
+	itxs      entryHeap
+	ctxs      entryHeap
+	itxFees   entryHeap // capacity > 0 时维护
+	ctxFees   entryHeap
+	byTime    entryHeap // ttl > 0 时维护
+	itxBase   entryHeap // 全部交易（含 relay2）按 base 升序，见 UpdateStarvation
+	ctxBase   entryHeap
+	starved   []*poolEntry // 上次 UpdateStarvation 时挨饿的交易，其中可能有已离开交易池的
+	accounts  map[Address]*account
+	queueLen  int            // ITX + relay1 交易数（含 queued）
+	queuedLen int            // 因 nonce 空缺暂不可打包的交易数
+	relayFrom map[uint64]int // 各源分片发来、尚未打包的 relay2 交易数
+	seq       int64          // 下一笔交易的进池顺序
+	headSeq   int64          // AddTxs2Pool_Head 使用的进池顺序，从 -1 递减，排在所有普通进池交易之前
+	capacity  int            // ITX + relay1 交易数上限，0 为不限
+	ttl       time.Duration  // 交易自 tx.Time 起在池中的存活时间，0 为不过期
+	priceBump int64          // 替换同 nonce 交易所需的最低 GasPrice 涨幅（%）
+	tp        *TaxPool       // 驱逐时按当前 Tax/Subsidy 计算收益
+	baseFee   *big.Int       // EIP-1559 模型下本分片下一个区块的 base fee，legacy 为 nil
+	agingStep int64          // 挨饿交易每多挨饿一个区块，排序用的 key 提高 agingStep，0 为不启用
+	drops     DropCounts     // 上次 TakeDropCounts 以来丢弃的交易数
+	replaced  int            // 上次 TakeReplaceCounts 以来被提价替换的交易数
+	cancelled int            // 上次 TakeReplaceCounts 以来被取消的交易数
 	lock      sync.Mutex
-	// The pending list is ignored
 }
 
+// DefaultPriceBump 替换池中交易默认要求 GasPrice 至少上涨 10%，与 geth 一致
+const DefaultPriceBump = 10
+
 func NewTxPool() *TxPool {
+	return NewBoundedTxPool(0, 0, DefaultPriceBump, nil)
+}
+
+// NewBoundedTxPool 容量为 capacity、交易存活时间为 ttl 的交易池（0 为不限），池满时按 tp 当前的 Tax/Subsidy 驱逐收益最低的交易；
+// 同发送方同 nonce 的交易 GasPrice 至少高出 priceBump% 才能替换池中交易
+func NewBoundedTxPool(capacity int, ttl time.Duration, priceBump int64, tp *TaxPool) *TxPool {
 	return &TxPool{
-		TxQueue:   make([]*Transaction, 0),
-		RelayPool: make(map[uint64][]*Transaction),
+		itxs:      entryHeap{slot: slotCompete, less: higherKey},
+		ctxs:      entryHeap{slot: slotCompete, less: higherKey},
+		itxFees:   entryHeap{slot: slotFee, less: lowerKey},
+		ctxFees:   entryHeap{slot: slotFee, less: lowerKey},
+		byTime:    entryHeap{slot: slotTime, less: earlierTime},
+		itxBase:   entryHeap{slot: slotBase, less: lowerBase},
+		ctxBase:   entryHeap{slot: slotBase, less: lowerBase},
+		accounts:  make(map[Address]*account),
+		relayFrom: make(map[uint64]int),
+		capacity:  capacity,
+		ttl:       ttl,
+		priceBump: priceBump,
+		tp:        tp,
+	}
+}
+
+// txKey 与税池无关的排序依据：ITX 为手续费，CTX 为手续费/2。baseFee 不为 nil 时手续费按 EIP-1559 取小费，
+// 出价低于 base fee 的交易 key 为负
+func txKey(tx *Transaction, baseFee *big.Int) *big.Int {
+	var fee *big.Int
+	if baseFee != nil && tx.MaxFeePerGas != nil {
+		fee = effectiveTip(tx, baseFee)
+		fee.Mul(fee, tx.GasUsed)
+	} else {
+		fee = new(big.Int).Mul(tx.GasPrice, tx.GasUsed)
+	}
+	if tx.isCTX {
+		fee.Div(fee, big.NewInt(2))
+	}
+	return fee
+}
+
+// setKey 按当前 base fee 计算 e.base 和排序用的 e.key：txKey 加上 aging 给挨饿交易的加成，出价低于 base fee 的交易不加
+func (txpool *TxPool) setKey(e *poolEntry) {
+	e.base = txKey(e.tx, txpool.baseFee)
+	key := new(big.Int).Set(e.base)
+	if e.starved > 0 && txpool.agingStep > 0 && key.Sign() >= 0 {
+		key.Add(key, new(big.Int).Mul(big.NewInt(txpool.agingStep), big.NewInt(e.starved)))
+	}
+	e.key = key
+}
+
+// profit 交易在当前 Tax/Subsidy 下的实际收益
+func (txpool *TxPool) profit(e *poolEntry) *big.Int {
+	if txpool.tp == nil {
+		return new(big.Int).Set(e.key)
+	}
+	return entryProfit(e, txpool.tp, txpool.baseFee)
+}
+
+// entryProfit 交易在 tp 的 Tax/Subsidy 下的实际收益，Tax/Subsidy 取税池能够支付的部分（见 expectedRates）。
+// itx 的税按 taxCap 封顶，封顶以不含 aging 加成的手续费计，与实际收税一致，aging 只提高排序用的收益
+func entryProfit(e *poolEntry, tp *TaxPool, baseFee *big.Int) *big.Int {
+	tax, subsidy := tp.expectedRates()
+	p := new(big.Int).Set(e.key)
+	if e.tx.isCTX {
+		return p.Add(p, subsidy)
+	}
+	if tp.cfg.Solvency.TaxCap > 0 {
+		tax = tp.cfg.Solvency.chargedTax(tax, txKey(e.tx, baseFee))
+	}
+	return p.Sub(p, tax)
+}
+
+// push 交易在 added 时刻进池，与池中交易 nonce 相同时按 replace 尝试替换；nonce 已被打包、替换涨价不足、
+// 或池满且收益不高于池中最低者时丢弃并返回 false，调用方需持有锁
+func (txpool *TxPool) push(tx *Transaction, seq int64, added time.Time) bool {
+	e := txpool.newEntry(tx, seq, added)
+	if tx.Relayed {
+		// 源分片打包 relay1 时写入的 ShardID 即 relay2 的源分片
+		txpool.addRelay(e, tx.ShardID)
+		return true
+	}
+
+	acc, ok := txpool.accounts[tx.Sender]
+	if !ok {
+		acc = &account{txs: make(map[uint64]*poolEntry)}
+		txpool.accounts[tx.Sender] = acc
+	}
+	n := tx.Nonce
+	if n < acc.next {
+		return false
+	}
+	if old, dup := acc.txs[n]; dup {
+		return txpool.replace(old, e)
+	}
+	if txpool.capacity > 0 && txpool.queueLen >= txpool.capacity {
+		// 池满：驱逐收益最低的交易；新交易收益不比它高、或依赖它（同账户更小的 nonce）时丢弃新交易
+		low := txpool.lowest()
+		if low == nil || txpool.profit(e).Cmp(txpool.profit(low)) <= 0 || (low.acc == acc && low.tx.Nonce < n) {
+			txpool.countDrop(tx, false)
+			return false
+		}
+		txpool.dropFrom(low.acc, low.tx.Nonce, false)
+	}
+	e.acc = acc
+	acc.txs[n] = e
+	txpool.queueLen++
+	txpool.track(e)
+
+	if n != acc.end {
+		txpool.queuedLen++
+		return true
+	}
+	// 补上了空缺：后面连续的 queued 交易一并变为 pending
+	acc.end++
+	for _, ok := acc.txs[acc.end]; ok; _, ok = acc.txs[acc.end] {
+		acc.end++
+		txpool.queuedLen--
+	}
+	if n == acc.next {
+		txpool.compete(e)
+	}
+	return true
+}
+
+// compete 把账户队首交易放入对应的堆参与打包竞争
+func (txpool *TxPool) compete(e *poolEntry) {
+	heap.Push(txpool.competeHeap(e), e)
+}
+
+func (txpool *TxPool) competeHeap(e *poolEntry) *entryHeap {
+	if e.tx.isCTX {
+		return &txpool.ctxs
+	}
+	return &txpool.itxs
+}
+
+func (txpool *TxPool) baseHeap(e *poolEntry) *entryHeap {
+	if e.tx.isCTX {
+		return &txpool.ctxBase
+	}
+	return &txpool.itxBase
+}
+
+// replace 用 e 替换池中同 nonce 的交易 old，e 的 GasPrice（EIP-1559 下为 MaxFeePerGas 和 MaxPriorityFeePerGas）
+// 须比 old 至少高 priceBump%。替换后 e 占据 old 在账户队列中的位置，ITX/CTX 类型可以不同（如取消跨分片交易）
+func (txpool *TxPool) replace(old, e *poolEntry) bool {
+	bumped := func(oldPrice, newPrice *big.Int) bool {
+		min := new(big.Int).Mul(oldPrice, big.NewInt(100+txpool.priceBump))
+		return new(big.Int).Mul(newPrice, big.NewInt(100)).Cmp(min) >= 0
+	}
+	if old.tx.MaxFeePerGas != nil && e.tx.MaxFeePerGas != nil {
+		if !bumped(old.tx.MaxFeePerGas, e.tx.MaxFeePerGas) || !bumped(old.tx.MaxPriorityFeePerGas, e.tx.MaxPriorityFeePerGas) {
+			return false
+		}
+	} else if !bumped(old.tx.GasPrice, e.tx.GasPrice) {
+		return false
+	}
+	acc := old.acc
+	e.acc = acc
+	acc.txs[e.tx.Nonce] = e
+	txpool.untrack(old)
+	txpool.track(e)
+	if old.idx[slotCompete] >= 0 {
+		txpool.competeHeap(old).remove(old)
+		txpool.compete(e)
+	}
+	if isCancel(e.tx) {
+		txpool.cancelled++
+	} else {
+		txpool.replaced++
+	}
+	return true
+}
+
+// isCancel 零金额转给自己的交易，用于取消池中同 nonce 的交易
+func isCancel(tx *Transaction) bool {
+	return tx.Sender == tx.Recipient && (tx.Value == nil || tx.Value.Sign() == 0)
+}
+
+// track 交易进池时放入 base 堆，并按需放入驱逐堆和过期堆
+func (txpool *TxPool) track(e *poolEntry) {
+	heap.Push(txpool.baseHeap(e), e)
+	if txpool.capacity > 0 {
+		if e.tx.isCTX {
+			heap.Push(&txpool.ctxFees, e)
+		} else {
+			heap.Push(&txpool.itxFees, e)
+		}
+	}
+	if txpool.ttl > 0 {
+		heap.Push(&txpool.byTime, e)
+	}
+}
+
+// untrack 交易离开交易池时从 base 堆、驱逐堆和过期堆中删除
+func (txpool *TxPool) untrack(e *poolEntry) {
+	txpool.baseHeap(e).remove(e)
+	if e.tx.isCTX {
+		txpool.ctxFees.remove(e)
+	} else {
+		txpool.itxFees.remove(e)
+	}
+	txpool.byTime.remove(e)
+}
+
+// addRelay 源分片 from 发来的 relay2 交易 e 参与竞争，调用方需持有锁
+func (txpool *TxPool) addRelay(e *poolEntry, from uint64) {
+	e.from = from
+	txpool.relayFrom[from]++
+	heap.Push(txpool.baseHeap(e), e)
+	txpool.compete(e)
+}
+
+// pop 从堆 h 取出收益最高的交易，其账户的下一笔 pending 交易补入堆中
+func (txpool *TxPool) pop(h *entryHeap) *Transaction {
+	e := h.items[0]
+	txpool.take(e)
+	return e.tx
+}
+
+// take 把参与竞争的交易 e 取出打包，返回其账户补入堆中的下一笔 pending 交易，没有则为 nil
+func (txpool *TxPool) take(e *poolEntry) *poolEntry {
+	txpool.competeHeap(e).remove(e)
+	if txpool.baseFee != nil && e.tx.MaxFeePerGas != nil {
+		e.tx.EffectiveTip = effectiveTip(e.tx, txpool.baseFee)
+	}
+	if e.acc == nil {
+		txpool.baseHeap(e).remove(e)
+		if txpool.relayFrom[e.from]--; txpool.relayFrom[e.from] == 0 {
+			delete(txpool.relayFrom, e.from)
+		}
+		return nil
+	}
+	txpool.queueLen--
+	txpool.untrack(e)
+	acc := e.acc
+	delete(acc.txs, acc.next)
+	acc.next++
+	if acc.next < acc.end {
+		next := acc.txs[acc.next]
+		txpool.compete(next)
+		return next
+	}
+	return nil
+}
+
+// lowest 当前收益最低的非 relay2 交易，收益相同时取后进池的
+func (txpool *TxPool) lowest() *poolEntry {
+	var low *poolEntry
+	var lowProfit *big.Int
+	for _, h := range []*entryHeap{&txpool.itxFees, &txpool.ctxFees} {
+		if h.Len() == 0 {
+			continue
+		}
+		e := h.items[0]
+		p := txpool.profit(e)
+		if low == nil {
+			low, lowProfit = e, p
+			continue
+		}
+		if c := p.Cmp(lowProfit); c < 0 || (c == 0 && e.seq > low.seq) {
+			low, lowProfit = e, p
+		}
+	}
+	return low
+}
+
+// dropFrom 丢弃账户中 nonce >= n 的全部交易（后面的交易缺了前序 nonce 已无法打包），
+// 之后到达的该账户交易 nonce 前面有空缺，留在 queued 中直至过期或被驱逐。调用方需持有锁
+func (txpool *TxPool) dropFrom(acc *account, n uint64, expired bool) {
+	for k, e := range acc.txs {
+		if k < n {
+			continue
+		}
+		delete(acc.txs, k)
+		txpool.competeHeap(e).remove(e)
+		txpool.untrack(e)
+		txpool.queueLen--
+		if k >= acc.end {
+			txpool.queuedLen--
+		}
+		txpool.countDrop(e.tx, expired)
+	}
+	if n < acc.end {
+		acc.end = n
+	}
+}
+
+func (txpool *TxPool) countDrop(tx *Transaction, expired bool) {
+	switch {
+	case expired && tx.isCTX:
+		txpool.drops.ExpiredCTX++
+	case expired:
+		txpool.drops.ExpiredITX++
+	case tx.isCTX:
+		txpool.drops.EvictedCTX++
+	default:
+		txpool.drops.EvictedITX++
 	}
 }
 
-// Add a transaction to the pool (consider the queue only)
+// Add a transaction to the pool (consider the queue only), tx.Time 由调用方按模拟时钟赋值
 func (txpool *TxPool) AddTx2Pool(tx *Transaction) {
 	txpool.lock.Lock()
 	defer txpool.lock.Unlock()
-	if tx.Time.IsZero() {
-		tx.Time = time.Now()
-	}
-	txpool.TxQueue = append(txpool.TxQueue, tx)
+	txpool.push(tx, txpool.seq, tx.Time)
+	txpool.seq++
 }
 
 // Add a list of transactions to the pool
@@ -36,84 +471,304 @@ func (txpool *TxPool) AddTxs2Pool(txs []*Transaction) {
 	txpool.lock.Lock()
 	defer txpool.lock.Unlock()
 	for _, tx := range txs {
-		if tx.Time.IsZero() {
-			tx.Time = time.Now()
-		}
-		txpool.TxQueue = append(txpool.TxQueue, tx)
+		txpool.push(tx, txpool.seq, tx.Time)
+		txpool.seq++
 	}
 }
 
-// add transactions into the pool head
-func (txpool *TxPool) AddTxs2Pool_Head(tx []*Transaction) {
+// add transactions into the pool head, 收益相同时先于池中已有交易被打包
+func (txpool *TxPool) AddTxs2Pool_Head(txs []*Transaction) {
 	txpool.lock.Lock()
 	defer txpool.lock.Unlock()
-	txpool.TxQueue = append(tx, txpool.TxQueue...)
+	txpool.headSeq -= int64(len(txs))
+	for i, tx := range txs {
+		txpool.push(tx, txpool.headSeq+int64(i), tx.Time)
+	}
 }
 
-// PackTxs Pack transactions for a proposal
-func (txpool *TxPool) PackTxs(max_txs uint64, tp *TaxPool) []*Transaction {
+// AddRelayTxs 目的分片收到源分片 fromShard 发来的 relay2 交易，与池中其他交易一起按收益竞争，按源分片计数
+func (txpool *TxPool) AddRelayTxs(fromShard uint64, txs []*Transaction) {
 	txpool.lock.Lock()
 	defer txpool.lock.Unlock()
+	for _, tx := range txs {
+		txpool.addRelay(txpool.newEntry(tx, txpool.seq, tx.Time), fromShard)
+		txpool.seq++
+	}
+}
 
-	// 分开加了税/补贴后为负和为正的交易，只打包收益为正的交易
-	positiveTxs := make([]*Transaction, 0, len(txpool.TxQueue))
-	negativeTxs := make([]*Transaction, 0) // 用于保留未选中交易
-	for _, tx := range txpool.TxQueue {
-		fee := new(big.Int).Mul(tx.GasPrice, tx.GasUsed)
-		if isCtx(tx.Sender, tx.Recipient) {
-			fee = new(big.Int).Div(fee, big.NewInt(2))
-			fee.Add(fee, tp.Subsidy)
-		} else {
-			fee.Sub(fee, tp.Tax)
+// newEntry 新建尚未进入任何堆的交易条目
+func (txpool *TxPool) newEntry(tx *Transaction, seq int64, added time.Time) *poolEntry {
+	e := &poolEntry{tx: tx, seq: seq, added: added}
+	txpool.setKey(e)
+	for i := range e.idx {
+		e.idx[i] = -1
+	}
+	return e
+}
+
+// SetBaseFee 设置本分片下一个区块的 base fee，池中交易按新的小费重新排序，代价 O(n)；
+// base fee 不变（如 gas 用量恰为目标值、或已到 0）时各交易的 key 不变，不重排
+func (txpool *TxPool) SetBaseFee(baseFee *big.Int) {
+	txpool.lock.Lock()
+	defer txpool.lock.Unlock()
+	unchanged := txpool.baseFee != nil && txpool.baseFee.Cmp(baseFee) == 0
+	txpool.baseFee = baseFee
+	if !unchanged {
+		txpool.rekeyAll()
+	}
+}
+
+// SetAging 开启 aging：挨饿交易每多挨饿一个区块，排序用的 key 提高 step，让它最终能被打包
+func (txpool *TxPool) SetAging(step int64) {
+	txpool.lock.Lock()
+	defer txpool.lock.Unlock()
+	txpool.agingStep = step
+}
+
+// rekey 重新计算 e 的 key 并在它所在的堆中调整位置，代价 O(log n)，调用方需持有锁
+func (txpool *TxPool) rekey(e *poolEntry) {
+	txpool.setKey(e)
+	txpool.competeHeap(e).fix(e)
+	txpool.baseHeap(e).fix(e)
+	if e.tx.isCTX {
+		txpool.ctxFees.fix(e)
+	} else {
+		txpool.itxFees.fix(e)
+	}
+}
+
+// rekeyAll 重新计算池中所有交易的 key 并重建各堆，调用方需持有锁
+func (txpool *TxPool) rekeyAll() {
+	// base 堆中即池中全部交易
+	for _, h := range []*entryHeap{&txpool.itxBase, &txpool.ctxBase} {
+		for _, e := range h.items {
+			txpool.setKey(e)
 		}
-		if fee.Sign() >= 0 {
-			positiveTxs = append(positiveTxs, tx)
-		} else {
-			negativeTxs = append(negativeTxs, tx)
+	}
+	for _, h := range []*entryHeap{&txpool.itxs, &txpool.ctxs, &txpool.itxFees, &txpool.ctxFees, &txpool.itxBase, &txpool.ctxBase} {
+		heap.Init(h)
+	}
+}
+
+// Resubmit 用户在 now 时刻重发 tx 替换池中同 nonce 的交易（提价或取消），返回是否被接受
+func (txpool *TxPool) Resubmit(tx *Transaction, now time.Time) bool {
+	txpool.lock.Lock()
+	defer txpool.lock.Unlock()
+	ok := txpool.push(tx, txpool.seq, now)
+	txpool.seq++
+	return ok
+}
+
+// StuckTxs 返回到 now 为止进池（或上次重发）超过 age 仍未打包的账户队首交易，按进池顺序，不含 relay2
+func (txpool *TxPool) StuckTxs(now time.Time, age time.Duration) []*Transaction {
+	txpool.lock.Lock()
+	defer txpool.lock.Unlock()
+	var stuck []*poolEntry
+	for _, h := range []*entryHeap{&txpool.itxs, &txpool.ctxs} {
+		for _, e := range h.items {
+			if e.acc != nil && now.Sub(e.added) >= age {
+				stuck = append(stuck, e)
+			}
 		}
 	}
+	sort.Slice(stuck, func(i, j int) bool { return stuck[i].seq < stuck[j].seq })
+	txs := make([]*Transaction, len(stuck))
+	for i, e := range stuck {
+		txs[i] = e.tx
+	}
+	return txs
+}
 
-	// 按手续费排序
-	sort.Slice(positiveTxs, func(i, j int) bool {
-		priceI := new(big.Int).Mul(positiveTxs[i].GasPrice, positiveTxs[i].GasUsed)
-		if isCtx(positiveTxs[i].Sender, positiveTxs[i].Recipient) {
-			priceI.Div(priceI, big.NewInt(2))
-			priceI.Add(priceI, tp.Subsidy)
-		} else {
-			priceI.Sub(priceI, tp.Tax)
+// Expire 丢弃到 now 为止在池中超过存活时间的交易，连同同账户 nonce 更大的交易
+func (txpool *TxPool) Expire(now time.Time) {
+	if txpool.ttl <= 0 {
+		return
+	}
+	txpool.lock.Lock()
+	defer txpool.lock.Unlock()
+	for txpool.byTime.Len() > 0 {
+		e := txpool.byTime.items[0]
+		if now.Sub(e.tx.Time) < txpool.ttl {
+			return
 		}
+		txpool.dropFrom(e.acc, e.tx.Nonce, true)
+	}
+}
 
-		priceJ := new(big.Int).Mul(positiveTxs[j].GasPrice, positiveTxs[j].GasUsed)
-		if isCtx(positiveTxs[j].Sender, positiveTxs[j].Recipient) {
-			priceJ.Div(priceJ, big.NewInt(2))
-			priceJ.Add(priceJ, tp.Subsidy)
-		} else {
-			priceJ.Sub(priceJ, tp.Tax)
+// TakeReplaceCounts 取出上次调用以来被提价替换、被取消的交易数
+func (txpool *TxPool) TakeReplaceCounts() (replaced, cancelled int) {
+	txpool.lock.Lock()
+	defer txpool.lock.Unlock()
+	replaced, cancelled = txpool.replaced, txpool.cancelled
+	txpool.replaced, txpool.cancelled = 0, 0
+	return replaced, cancelled
+}
+
+// TakeDropCounts 取出上次调用以来被驱逐、过期的交易数
+func (txpool *TxPool) TakeDropCounts() DropCounts {
+	txpool.lock.Lock()
+	defer txpool.lock.Unlock()
+	out := txpool.drops
+	txpool.drops = DropCounts{}
+	return out
+}
+
+// PackTxs Pack transactions for a proposal, relay2 交易和各账户的队首交易一起按收益竞争，
+// 只打包加了税/补贴后收益非负的交易，收益为负的留在池中（同账户后续交易也随之等待）；
+// EIP-1559 模型下出价低于 base fee（key 为负）的交易不打包
+func (txpool *TxPool) PackTxs(max_txs uint64, tp *TaxPool) []*Transaction {
+	txpool.lock.Lock()
+	defer txpool.lock.Unlock()
+
+	_, subsidy := tp.expectedRates()
+	packed := make([]*Transaction, 0, max_txs)
+	for uint64(len(packed)) < max_txs {
+		// 两个堆顶各自的实际收益，为负则该类交易本块不再打包
+		var itxProfit, ctxProfit *big.Int
+		if txpool.itxs.Len() > 0 {
+			itxProfit = entryProfit(txpool.itxs.items[0], tp, txpool.baseFee)
+			if itxProfit.Sign() < 0 || txpool.itxs.items[0].key.Sign() < 0 {
+				itxProfit = nil
+			}
+		}
+		if txpool.ctxs.Len() > 0 {
+			ctxProfit = new(big.Int).Add(txpool.ctxs.items[0].key, subsidy)
+			if ctxProfit.Sign() < 0 || txpool.ctxs.items[0].key.Sign() < 0 {
+				ctxProfit = nil
+			}
 		}
-		return priceI.Cmp(priceJ) > 0
-	})
 
-	// 最多只打包blocksize个交易
-	if uint64(len(positiveTxs)) > max_txs {
-		positiveTxs = positiveTxs[:max_txs]
+		var from *entryHeap
+		switch {
+		case itxProfit == nil && ctxProfit == nil:
+			return packed
+		case ctxProfit == nil:
+			from = &txpool.itxs
+		case itxProfit == nil:
+			from = &txpool.ctxs
+		default:
+			c := itxProfit.Cmp(ctxProfit)
+			if c > 0 || (c == 0 && txpool.itxs.items[0].seq < txpool.ctxs.items[0].seq) {
+				from = &txpool.itxs
+			} else {
+				from = &txpool.ctxs
+			}
+		}
+
+		packed = append(packed, txpool.pop(from))
 	}
+	return packed
+}
 
-	remaining := make([]*Transaction, 0, len(txpool.TxQueue)-len(positiveTxs))
-	packedMap := make(map[*Transaction]bool)
-	for _, tx := range positiveTxs {
-		packedMap[tx] = true
+// packCandidate 按规则打包时的候选交易
+type packCandidate struct {
+	e      *poolEntry
+	profit *big.Int // 加了税/补贴后的收益
+	gas    *big.Int // 本段计的 gas，见 legGas
+	rnd    int64    // packRule.rng 不为 nil 时抽取的随机排序值
+}
+
+// packRule 打包规则：better 决定候选交易的先后，eligible 为 nil 时收益非负的交易都可打包
+type packRule struct {
+	better   func(a, b *packCandidate) bool
+	eligible func(c *packCandidate) bool
+	rng      *rand.Rand
+}
+
+// packHeap 按 packRule.better 排序的候选堆
+type packHeap struct {
+	items []*packCandidate
+	rule  *packRule
+}
+
+func (h *packHeap) Len() int           { return len(h.items) }
+func (h *packHeap) Less(i, j int) bool { return h.rule.better(h.items[i], h.items[j]) }
+func (h *packHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *packHeap) Push(x any)         { h.items = append(h.items, x.(*packCandidate)) }
+func (h *packHeap) Pop() any {
+	n := len(h.items)
+	c := h.items[n-1]
+	h.items[n-1] = nil
+	h.items = h.items[:n-1]
+	return c
+}
+
+// BlockLimit 区块容量：GasLimit 大于 0 时按 gas，否则最多 MaxTxs 笔
+type BlockLimit struct {
+	MaxTxs   int
+	GasLimit int64
+}
+
+// higherProfitPerGas 加了税/补贴后每 gas 收益高的在前，交叉相乘比较避免除法
+func higherProfitPerGas(a, b *packCandidate) bool {
+	x := new(big.Int).Mul(a.profit, b.gas)
+	y := new(big.Int).Mul(b.profit, a.gas)
+	if c := x.Cmp(y); c != 0 {
+		return c > 0
 	}
-	for _, tx := range txpool.TxQueue {
-		if !packedMap[tx] {
-			remaining = append(remaining, tx)
+	return a.e.seq < b.e.seq
+}
+
+// PackTxsByGas 按 gas 上限打包：每 gas 收益随 Tax/Subsidy 变化，所以每块对当前参与竞争的交易重新建堆，
+// 贪心地取每 gas 收益最高的交易，放不下的跳过（留在池中）、继续用更小的交易填满剩余 gas。
+// 只打包收益非负、出价不低于 base fee 的交易，代价 O(n log n)
+func (txpool *TxPool) PackTxsByGas(gasLimit int64, tp *TaxPool) []*Transaction {
+	return txpool.packBy(BlockLimit{GasLimit: gasLimit}, tp, &packRule{better: higherProfitPerGas})
+}
+
+// packBy 按 rule 打包：对当前参与竞争的交易（relay2 和各账户队首）建堆，依次取出最优的一笔，
+// 按 gas 打包时放不下的跳过；打包后账户的下一笔交易加入候选。收益为负、出价低于 base fee 的交易一律不打包
+func (txpool *TxPool) packBy(limit BlockLimit, tp *TaxPool, rule *packRule) []*Transaction {
+	txpool.lock.Lock()
+	defer txpool.lock.Unlock()
+
+	candidate := func(e *poolEntry) (*packCandidate, bool) {
+		c := &packCandidate{e: e, profit: entryProfit(e, tp, txpool.baseFee), gas: legGas(e.tx)}
+		if c.profit.Sign() < 0 || e.key.Sign() < 0 {
+			return nil, false
+		}
+		if rule.eligible != nil && !rule.eligible(c) {
+			return nil, false
 		}
+		if rule.rng != nil {
+			c.rnd = rule.rng.Int63()
+		}
+		return c, true
 	}
+	cands := &packHeap{rule: rule}
+	// 按进池顺序加入候选，保证随机排序值的抽取顺序可复现
+	var heads []*poolEntry
+	heads = append(heads, txpool.itxs.items...)
+	heads = append(heads, txpool.ctxs.items...)
+	sort.Slice(heads, func(i, j int) bool { return heads[i].seq < heads[j].seq })
+	for _, e := range heads {
+		if c, ok := candidate(e); ok {
+			cands.items = append(cands.items, c)
+		}
+	}
+	heap.Init(cands)
 
-	// 恢复包括此次收益为负的交易的交易队列
-	txpool.TxQueue = remaining
-
-	// 返回此此次要打包的交易
-	return positiveTxs
+	var packed []*Transaction
+	remaining := big.NewInt(limit.GasLimit)
+	for cands.Len() > 0 {
+		if limit.GasLimit <= 0 && len(packed) >= limit.MaxTxs {
+			break
+		}
+		c := heap.Pop(cands).(*packCandidate)
+		if limit.GasLimit > 0 {
+			if c.gas.Cmp(remaining) > 0 {
+				continue
+			}
+			remaining.Sub(remaining, c.gas)
+		}
+		packed = append(packed, c.e.tx)
+		if next := txpool.take(c.e); next != nil {
+			if nc, ok := candidate(next); ok {
+				heap.Push(cands, nc)
+			}
+		}
+	}
+	return packed
 }
 
 // txpool get locked
@@ -126,33 +781,34 @@ func (txpool *TxPool) GetUnlocked() {
 	txpool.lock.Unlock()
 }
 
-// get the length of tx queue
+// get the number of txs waiting in the pool, relay2 交易除外，含 queued
 func (txpool *TxPool) GetTxQueueLen() int {
 	txpool.lock.Lock()
 	defer txpool.lock.Unlock()
-	return len(txpool.TxQueue)
+	return txpool.queueLen
 }
 
-// sort by 手续费(手续费/2 if relayTX)
-func sortTxQueue(txQueue []*Transaction, tp *TaxPool) {
-	sort.Slice(txQueue, func(i, j int) bool {
-		priceI := new(big.Int).Mul(txQueue[i].GasPrice, txQueue[i].GasUsed)
-		isCTX := isCtx(txQueue[i].Sender, txQueue[i].Recipient)
-		if isCTX {
-			priceI = new(big.Int).Div(priceI, big.NewInt(2))
-			priceI.Add(priceI, tp.Subsidy)
-		} else {
-			priceI.Sub(priceI, tp.Tax)
-		}
+// get the number of txs blocked by a nonce gap
+func (txpool *TxPool) GetQueuedLen() int {
+	txpool.lock.Lock()
+	defer txpool.lock.Unlock()
+	return txpool.queuedLen
+}
 
-		priceJ := new(big.Int).Mul(txQueue[j].GasPrice, txQueue[j].GasUsed)
-		isCTX = isCtx(txQueue[j].Sender, txQueue[j].Recipient)
-		if isCTX {
-			priceJ = new(big.Int).Div(priceJ, big.NewInt(2))
-			priceJ.Add(priceJ, tp.Subsidy)
-		} else {
-			priceJ.Sub(priceJ, tp.Tax)
-		}
-		return priceI.Cmp(priceJ) > 0
-	})
+// get the number of relay2 txs waiting in the relay pool
+func (txpool *TxPool) GetRelayPoolLen() int {
+	txpool.lock.Lock()
+	defer txpool.lock.Unlock()
+	n := 0
+	for _, c := range txpool.relayFrom {
+		n += c
+	}
+	return n
+}
+
+// get the number of relay2 txs from shard fromShard waiting in the relay pool
+func (txpool *TxPool) GetRelayPoolLenFrom(fromShard uint64) int {
+	txpool.lock.Lock()
+	defer txpool.lock.Unlock()
+	return txpool.relayFrom[fromShard]
 }
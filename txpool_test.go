@@ -0,0 +1,41 @@
+package main
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+// 同一账户的交易按给定的 nonce 顺序进池，检查每次进池后的 queued 数和最终按 nonce 顺序打包的结果
+func TestNonceQueuedThenPromoted(t *testing.T) {
+	cases := []struct {
+		name       string
+		arrive     []uint64
+		wantQueued []int // 每笔进池后的 queued 交易数
+		wantPacked []uint64
+	}{
+		{"按序到达", []uint64{0, 1, 2}, []int{0, 0, 0}, []uint64{0, 1, 2}},
+		{"nonce 1 先于 0", []uint64{1, 0}, []int{1, 0}, []uint64{0, 1}},
+		{"逆序到达", []uint64{2, 1, 0}, []int{1, 2, 0}, []uint64{0, 1, 2}},
+		{"空缺未补上", []uint64{0, 2}, []int{0, 1}, []uint64{0}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pool := NewTxPool()
+			tp := NewTaxPool(DefaultConfig(), make(chan string, 16))
+			for i, n := range c.arrive {
+				pool.AddTx2Pool(&Transaction{Sender: "a", Recipient: "b", Nonce: n, GasPrice: big.NewInt(1), GasUsed: big.NewInt(21000)})
+				if got := pool.GetQueuedLen(); got != c.wantQueued[i] {
+					t.Fatalf("nonce %d 进池后 queued = %d, want %d", n, got, c.wantQueued[i])
+				}
+			}
+			var packed []uint64
+			for _, tx := range pool.PackTxs(10, tp) {
+				packed = append(packed, tx.Nonce)
+			}
+			if !reflect.DeepEqual(packed, c.wantPacked) {
+				t.Errorf("打包的 nonce = %v, want %v", packed, c.wantPacked)
+			}
+		})
+	}
+}
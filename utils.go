@@ -7,7 +7,7 @@ import (
 	"time"
 )
 
-func Addr2Shard(addr Address) int {
+func Addr2Shard(addr Address, shardNum int) int {
 	last8_addr := addr
 	if len(last8_addr) > 8 {
 		last8_addr = last8_addr[len(last8_addr)-8:]
@@ -16,7 +16,7 @@ func Addr2Shard(addr Address) int {
 	if err != nil {
 		log.Panic(err)
 	}
-	return int(num) % ShardNum
+	return int(num) % shardNum
 }
 
 // transform data to transaction
@@ -46,7 +46,7 @@ func data2tx(data []string, nonce uint64) (*Transaction, bool) {
 			gasPrice,    // gasPrice
 			gasUsed,     // gasUsed
 			nonce,       // nonce
-			time.Now(),  // timestamp
+			time.Time{}, // timestamp, 注入交易池时按模拟时钟赋值
 		)
 		return tx, true
 	}
@@ -62,6 +62,11 @@ func safeStr(v *big.Int) string {
 
 // GetFactor factor计算函数，根据当前偏离值和epsilon容忍区间决定
 func GetFactor(deviation, epsilon *big.Int) *big.Float {
+	return GetFactorInRange(deviation, epsilon, big.NewFloat(1), big.NewFloat(8.0))
+}
+
+// GetFactorInRange factor = |偏离|/epsilon，限制在 [minFactor, maxFactor] 内
+func GetFactorInRange(deviation, epsilon *big.Int, minFactor, maxFactor *big.Float) *big.Float {
 	absDev := new(big.Float).SetInt(new(big.Int).Abs(deviation))
 	eps := new(big.Float).SetInt(epsilon)
 
@@ -72,9 +77,6 @@ func GetFactor(deviation, epsilon *big.Int) *big.Float {
 	factor := new(big.Float).Quo(absDev, eps)
 
 	// 上下界
-	minFactor := big.NewFloat(1)
-	maxFactor := big.NewFloat(8.0)
-
 	if factor.Cmp(minFactor) < 0 {
 		return minFactor
 	}